@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package fscrawl
+
+import "os"
+
+// identify is not supported on this platform, so device boundaries and symlink loops can not be detected
+func identify(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
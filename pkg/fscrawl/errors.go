@@ -0,0 +1,140 @@
+package fscrawl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrorPolicy defines how the crawler treats paths it fails to handle
+type ErrorPolicy int
+
+const (
+	// ErrorAbort stops the crawl at the first error
+	ErrorAbort ErrorPolicy = iota
+	// ErrorSkip records the failed path and continues with the next one
+	ErrorSkip
+	// ErrorRetry runs the actions of a failed path again with growing delays before skipping it
+	ErrorRetry
+)
+
+// defaults of ErrorRetry
+const (
+	DefaultRetries      = 3
+	DefaultRetryBackoff = time.Second
+)
+
+// ParseErrorPolicy from its flag representation
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch s {
+	case "abort", "":
+		return ErrorAbort, nil
+	case "skip":
+		return ErrorSkip, nil
+	case "retry":
+		return ErrorRetry, nil
+	}
+	return ErrorAbort, fmt.Errorf("unsupported error policy %q", s)
+}
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case ErrorSkip:
+		return "skip"
+	case ErrorRetry:
+		return "retry"
+	}
+	return "abort"
+}
+
+// Failure of a single path
+type Failure struct {
+	Path string
+	Err  error
+}
+
+// CrawlError lists all paths which failed during a crawl that continued on errors
+type CrawlError struct {
+	Failures []Failure
+}
+
+// maxListedFailures limits the failures listed by CrawlError.Error
+const maxListedFailures = 10
+
+func (e *CrawlError) Error() string {
+	listed := make([]string, 0, maxListedFailures)
+	for i, f := range e.Failures {
+		if i == maxListedFailures {
+			listed = append(listed, fmt.Sprintf("and %d more", len(e.Failures)-i))
+			break
+		}
+		listed = append(listed, fmt.Sprintf("%s: %v", f.Path, f.Err))
+	}
+	return fmt.Sprintf("%d paths failed: %s", len(e.Failures), strings.Join(listed, "; "))
+}
+
+// WithErrorPolicy sets how failing paths are treated
+func (c *Crawler) WithErrorPolicy(p ErrorPolicy) *Crawler {
+	c.errorPolicy = p
+	return c
+}
+
+// WithRetry sets how often the actions of a path are run in total with ErrorRetry
+// and the delay before the first retry, which doubles with every further one
+func (c *Crawler) WithRetry(attempts int, backoff time.Duration) *Crawler {
+	c.retries = attempts
+	c.backoff = backoff
+	return c
+}
+
+// handle the path according to the error policy
+// Errors found while walking are not retried, neither are files which vanished in the meantime.
+// Once the crawl is cancelled, failures abort it regardless of the policy
+func (w *walker) handle(path string, info os.FileInfo, walkErr error) error {
+	err := w.c.handle(w.ctx, path, info, walkErr)
+	retry := walkErr == nil && w.c.errorPolicy == ErrorRetry
+	for attempt := 1; err != nil && retry && attempt < w.c.retries && !os.IsNotExist(err) && w.ctx.Err() == nil; attempt++ {
+		delay := w.c.backoff << uint(attempt-1)
+		w.c.log.Warn("retrying failed path",
+			zap.String("path", path),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(delay):
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+		err = w.c.handle(w.ctx, path, info, nil)
+	}
+	if err == nil || w.c.errorPolicy == ErrorAbort {
+		return err
+	}
+	if w.ctx.Err() != nil {
+		return w.ctx.Err()
+	}
+	w.c.log.Warn("skipping failed path", zap.String("path", path), zap.Error(err))
+	w.m.Lock()
+	w.failures = append(w.failures, Failure{Path: path, Err: err})
+	w.m.Unlock()
+	return nil
+}
+
+// result of the crawl, the aggregate of all failures if it continued on errors
+func (w *walker) result(err error) error {
+	if err != nil || len(w.failures) == 0 {
+		return err
+	}
+	// workers finish in no particular order
+	sort.Slice(w.failures, func(i, j int) bool { return w.failures[i].Path < w.failures[j].Path })
+	w.c.log.Error("crawl finished with failed paths", zap.String("dir", w.root), zap.Int("failed", len(w.failures)))
+	for _, f := range w.failures {
+		w.c.log.Error("failed path", zap.String("path", f.Path), zap.Error(f.Err))
+	}
+	return &CrawlError{Failures: w.failures}
+}
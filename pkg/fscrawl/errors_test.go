@@ -0,0 +1,141 @@
+package fscrawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	for _, p := range []ErrorPolicy{ErrorAbort, ErrorSkip, ErrorRetry} {
+		got, err := ParseErrorPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseErrorPolicy(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseErrorPolicy("ignore"); err == nil {
+		t.Errorf("ParseErrorPolicy() error = nil for unsupported policy")
+	}
+}
+
+// flaky is an action failing for paths as long as they have failures left
+type flaky struct {
+	failures map[string]int
+	calls    map[string]int
+}
+
+func (f *flaky) action(ctx context.Context, path string, file os.FileInfo) error {
+	f.calls[path]++
+	if f.failures[path] != 0 {
+		f.failures[path]--
+		return errors.New("testError")
+	}
+	return nil
+}
+
+func TestCrawler_ErrorPolicy(t *testing.T) {
+	fs := vfs.NewMem()
+	for _, name := range []string{"data/a.log", "data/b.log", "data/c.log"} {
+		if err := fs.MkdirAll("data", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(name, []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name      string
+		policy    ErrorPolicy
+		failures  map[string]int
+		wantCalls map[string]int
+		wantErr   bool
+		wantPaths []string
+	}{
+		{
+			"abort",
+			ErrorAbort,
+			map[string]int{"data/b.log": 1},
+			map[string]int{"data": 1, "data/a.log": 1, "data/b.log": 1},
+			true,
+			nil,
+		},
+		{
+			"skip",
+			ErrorSkip,
+			map[string]int{"data/a.log": 1, "data/b.log": 5},
+			map[string]int{"data": 1, "data/a.log": 1, "data/b.log": 1, "data/c.log": 1},
+			true,
+			[]string{"data/a.log", "data/b.log"},
+		},
+		{
+			"retry",
+			ErrorRetry,
+			map[string]int{"data/a.log": 2, "data/b.log": 5},
+			map[string]int{"data": 1, "data/a.log": 3, "data/b.log": 3, "data/c.log": 1},
+			true,
+			[]string{"data/b.log"},
+		},
+		{
+			"retrySucceeds",
+			ErrorRetry,
+			map[string]int{"data/c.log": 1},
+			map[string]int{"data": 1, "data/a.log": 1, "data/b.log": 1, "data/c.log": 2},
+			false,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flaky{failures: tt.failures, calls: make(map[string]int)}
+			c := NewCrawler(log.NewNop(), f.action).
+				WithFilesystem(fs).
+				WithErrorPolicy(tt.policy).
+				WithRetry(3, time.Millisecond)
+			erc := make(chan error)
+			go c.Run(context.Background(), model.Directory("data"), erc)
+			err := <-erc
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Crawler.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(f.calls) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("Crawler.Run() calls = %v, want %v", f.calls, tt.wantCalls)
+			}
+			if tt.wantPaths == nil {
+				if _, ok := err.(*CrawlError); ok {
+					t.Errorf("Crawler.Run() error = %v, want no aggregate", err)
+				}
+				return
+			}
+			cerr, ok := err.(*CrawlError)
+			if !ok {
+				t.Fatalf("Crawler.Run() error = %T, want *CrawlError", err)
+			}
+			var paths []string
+			for _, f := range cerr.Failures {
+				paths = append(paths, f.Path)
+			}
+			if fmt.Sprint(paths) != fmt.Sprint(tt.wantPaths) {
+				t.Errorf("Crawler.Run() failed paths = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestCrawlError_Error(t *testing.T) {
+	e := &CrawlError{}
+	for i := 0; i < 12; i++ {
+		e.Failures = append(e.Failures, Failure{Path: fmt.Sprintf("%d.log", i), Err: errors.New("testError")})
+	}
+	got := e.Error()
+	if !strings.HasPrefix(got, "12 paths failed: 0.log: testError; ") || !strings.HasSuffix(got, "9.log: testError; and 2 more") {
+		t.Errorf("CrawlError.Error() = %q", got)
+	}
+}
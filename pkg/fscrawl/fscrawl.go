@@ -1,20 +1,38 @@
 package fscrawl
 
 import (
+	"context"
 	"errors"
-	"github.com/playnet-public/libs/log"
 	"os"
-	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/playnet-public/libs/log"
 
 	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
 	"go.uber.org/zap"
 )
 
 // Crawler defines the dir crawling handler
 type Crawler struct {
-	log       *log.Logger
-	interrupt chan bool
-	actions   []model.Action
+	log     *log.Logger
+	actions []model.Action
+	fs      vfs.Filesystem
+	rules   Rules
+	// ignoreFiles found in the crawled dirs are honored
+	ignoreFiles bool
+
+	// stop cancels all runs, running waits for them to return
+	m       sync.Mutex
+	stopped bool
+	stop    chan struct{}
+	running sync.WaitGroup
+
+	concurrency int
+	errorPolicy ErrorPolicy
+	retries     int
+	backoff     time.Duration
 }
 
 // NewCrawler with logger
@@ -23,40 +41,90 @@ func NewCrawler(log *log.Logger, actions ...model.Action) *Crawler {
 		actions = []model.Action{model.NoOpAction}
 	}
 	return &Crawler{
-		log:       log,
-		interrupt: make(chan bool),
-		actions:   actions,
+		log:     log,
+		actions: actions,
+		fs:      vfs.OS{},
+		stop:    make(chan struct{}),
+		retries: DefaultRetries,
+		backoff: DefaultRetryBackoff,
+
+		ignoreFiles: true,
 	}
 }
 
+// WithFilesystem crawls fs instead of the os file system
+func (c *Crawler) WithFilesystem(fs vfs.Filesystem) *Crawler {
+	c.fs = fs
+	return c
+}
+
+// WithRules limiting the paths handed to the actions
+func (c *Crawler) WithRules(rules Rules) *Crawler {
+	c.rules = rules
+	return c
+}
+
+// WithIgnoreFiles sets whether the ignore files found in the dirs are honored, which they are by default
+func (c *Crawler) WithIgnoreFiles(enabled bool) *Crawler {
+	c.ignoreFiles = enabled
+	return c
+}
+
 // Validate crawler integrity
 func (c *Crawler) Validate() error {
 	if c.log == nil {
 		return errors.New("log must not be nil")
 	}
-	return nil
+	return c.rules.Validate()
 }
 
-// Run the crawler for dir
-func (c *Crawler) Run(dir model.Directory, erc chan error) {
+// Run the crawler for dir until it finished or ctx is done
+// Files already being handled are finished, the crawl then reports the error of ctx
+func (c *Crawler) Run(ctx context.Context, dir model.Directory, erc chan error) {
 	c.log.Info("start handling", zap.String("dir", dir.String()), zap.String("handler", "crawler"))
 	defer c.log.Info("stop handling", zap.String("dir", dir.String()), zap.String("handler", "crawler"))
 	if len(dir) < 1 {
 		erc <- errors.New("invalid dir")
 		return
 	}
-	err := filepath.Walk(dir.String(), c.handle)
-	erc <- err
+	err := c.run(ctx, dir.String())
+	select {
+	case erc <- err:
+	case <-c.stop:
+	}
+}
+
+// run a crawl of root which is cancelled by Stop as well
+func (c *Crawler) run(ctx context.Context, root string) error {
+	c.m.Lock()
+	if c.stopped {
+		c.m.Unlock()
+		return errors.New("crawler stopped")
+	}
+	c.running.Add(1)
+	c.m.Unlock()
+	defer c.running.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return c.crawl(ctx, root)
 }
 
-func (c *Crawler) handle(path string, file os.FileInfo, err error) error {
+func (c *Crawler) handle(ctx context.Context, path string, file os.FileInfo, err error) error {
 	if err != nil {
 		c.log.Error("unable to handle path", zap.String("path", path), zap.Error(err))
 		return err
 	}
 	c.log.Info("handling path", zap.String("path", path))
 	for _, a := range c.actions {
-		err := a(path, file)
+		err := a(ctx, path, file)
 		if err != nil {
 			return err
 		}
@@ -64,5 +132,13 @@ func (c *Crawler) handle(path string, file os.FileInfo, err error) error {
 	return nil
 }
 
-// Stop crawling
-func (c *Crawler) Stop() {}
+// Stop all crawls, waiting for the files in progress
+func (c *Crawler) Stop() {
+	c.m.Lock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.stop)
+	}
+	c.m.Unlock()
+	c.running.Wait()
+}
@@ -1,12 +1,20 @@
 package fscrawl
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/playnet-public/fscrub/pkg/fscrub"
+	"github.com/playnet-public/fscrub/pkg/fscrub/patterns/intelligentIP"
 	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
 	"github.com/playnet-public/libs/log"
 )
 
@@ -79,7 +87,7 @@ func TestCrawler_Run(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			erc := make(chan error)
-			go tt.c.Run(tt.path, erc)
+			go tt.c.Run(context.Background(), tt.path, erc)
 			err := <-erc
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Crawler.Run() error = %v, wantErr %v", err, tt.wantErr)
@@ -97,6 +105,111 @@ func TestCrawler_Run(t *testing.T) {
 	}
 }
 
-func errorAction(path string, file os.FileInfo) error {
+func TestCrawler_Filesystem(t *testing.T) {
+	l := log.NewNop()
+	fs := vfs.NewMem()
+	if err := fs.MkdirAll("data/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("data/sub/log.txt", []byte("foo from 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("data/clean.txt", []byte("abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scrub := fscrub.NewFscrub(l, false, fscrub.NewStringPattern("foo", "bar"), intelligentIP.New()).WithFilesystem(fs)
+	c := NewCrawler(l, scrub.Handle).WithFilesystem(fs)
+	erc := make(chan error)
+	go c.Run(context.Background(), "data", erc)
+	if err := <-erc; err != nil {
+		t.Fatalf("Crawler.Run() error = %v", err)
+	}
+
+	data, err := vfs.ReadFile(fs, "data/sub/log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(primitives.BuildHeaderWithPatternSet(scrub.Fingerprint()), "\n") + "\nbar from client0.ip.fscrub.org\n"
+	if string(data) != want {
+		t.Errorf("Crawler.Run() scrubbed = %q, want %q", data, want)
+	}
+	data, err = vfs.ReadFile(fs, "data/clean.txt")
+	if err != nil || string(data) != "abc\n" {
+		t.Errorf("Crawler.Run() modified clean file = %q, %v", data, err)
+	}
+	if s := scrub.Summary(); s.Files != 2 || s.Changed != 1 {
+		t.Errorf("Crawler.Run() handled %v, want 2 files, 1 changed", s)
+	}
+}
+
+func TestCrawler_Cancel(t *testing.T) {
+	l := log.NewNop()
+	fs := vfs.NewMem()
+	if err := fs.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := fs.WriteFile(fmt.Sprintf("data/%02d.txt", i), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		workers int
+		stop    bool
+	}{
+		{"cancel", 1, false},
+		{"cancelWorkers", 4, false},
+		{"stop", 1, true},
+		{"stopWorkers", 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m sync.Mutex
+			handled := 0
+			started := make(chan struct{}, 20)
+			// files block until the crawl gets cancelled
+			action := func(ctx context.Context, path string, file os.FileInfo) error {
+				if file.IsDir() {
+					return nil
+				}
+				m.Lock()
+				handled++
+				m.Unlock()
+				started <- struct{}{}
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			c := NewCrawler(l, action).WithFilesystem(fs).WithConcurrency(tt.workers).WithErrorPolicy(ErrorSkip)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			erc := make(chan error, 1)
+			go c.Run(ctx, "data", erc)
+			<-started
+
+			if tt.stop {
+				c.Stop()
+			} else {
+				cancel()
+				select {
+				case err := <-erc:
+					if err != context.Canceled {
+						t.Errorf("Crawler.Run() error = %v, want %v", err, context.Canceled)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("Crawler.Run() did not return once ctx was done")
+				}
+			}
+			m.Lock()
+			defer m.Unlock()
+			if handled > tt.workers {
+				t.Errorf("Crawler.Run() handled %d files after being cancelled, want at most %d", handled, tt.workers)
+			}
+		})
+	}
+}
+
+func errorAction(ctx context.Context, path string, file os.FileInfo) error {
 	return errors.New("testError")
 }
@@ -0,0 +1,95 @@
+package fscrawl
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// errAborted stops enumerating once a worker failed with ErrorAbort
+var errAborted = errors.New("crawl aborted")
+
+// WithConcurrency sets the number of workers running the actions for files, while dirs are enumerated by a single one
+// Files are handled in no particular order then. 1 or less handles all paths sequentially in lexical order
+func (c *Crawler) WithConcurrency(workers int) *Crawler {
+	c.concurrency = workers
+	return c
+}
+
+// job is a file waiting for a worker
+type job struct {
+	path string
+	info os.FileInfo
+}
+
+// pool of workers handling the files of a single crawl
+type pool struct {
+	jobs chan job
+	wg   sync.WaitGroup
+
+	stop    chan struct{}
+	once    sync.Once
+	aborted error
+}
+
+// start the workers of w
+func (w *walker) start(workers int) {
+	p := &pool{
+		jobs: make(chan job),
+		stop: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for j := range p.jobs {
+				select {
+				case <-p.stop:
+					continue
+				case <-w.ctx.Done():
+					continue
+				default:
+				}
+				if err := w.handle(j.path, j.info, nil); err != nil {
+					p.abort(err)
+				}
+			}
+		}()
+	}
+	w.pool = p
+}
+
+// file is handed to a worker, or handled right away without workers
+func (w *walker) file(path string, info os.FileInfo) error {
+	if w.pool == nil {
+		return w.handle(path, info, nil)
+	}
+	select {
+	case w.pool.jobs <- job{path: path, info: info}:
+		return nil
+	case <-w.pool.stop:
+		return errAborted
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// finish waits for all queued files, returning the error which aborted the crawl if any
+func (w *walker) finish(err error) error {
+	if w.pool == nil {
+		return err
+	}
+	close(w.pool.jobs)
+	w.pool.wg.Wait()
+	if w.pool.aborted != nil {
+		return w.pool.aborted
+	}
+	return err
+}
+
+func (p *pool) abort(err error) {
+	p.once.Do(func() {
+		p.aborted = err
+		close(p.stop)
+	})
+}
@@ -0,0 +1,109 @@
+package fscrawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+// gauge is an action tracking how many files are handled at once
+type gauge struct {
+	m       sync.Mutex
+	calls   map[string]int
+	active  int
+	maximum int
+	fail    map[string]bool
+}
+
+func (g *gauge) action(ctx context.Context, path string, file os.FileInfo) error {
+	if file.IsDir() {
+		return nil
+	}
+	g.m.Lock()
+	g.calls[path]++
+	g.active++
+	if g.active > g.maximum {
+		g.maximum = g.active
+	}
+	g.m.Unlock()
+	time.Sleep(time.Millisecond * 5)
+	g.m.Lock()
+	g.active--
+	g.m.Unlock()
+	if g.fail[path] {
+		return errors.New("testError")
+	}
+	return nil
+}
+
+func TestCrawler_Concurrency(t *testing.T) {
+	fs := vfs.NewMem()
+	var files []string
+	for d := 0; d < 4; d++ {
+		for f := 0; f < 10; f++ {
+			name := fmt.Sprintf("data/%d/%d.log", d, f)
+			if err := fs.MkdirAll(fmt.Sprintf("data/%d", d), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.WriteFile(name, []byte("abc"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, name)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		workers     int
+		policy      ErrorPolicy
+		fail        map[string]bool
+		wantMaximum int
+		wantErr     bool
+		wantAll     bool
+		wantFailed  int
+	}{
+		{"sequential", 1, ErrorAbort, nil, 1, false, true, 0},
+		{"parallel", 4, ErrorAbort, nil, 4, false, true, 0},
+		{"skip", 4, ErrorSkip, map[string]bool{"data/1/3.log": true, "data/0/0.log": true}, 4, true, true, 2},
+		{"abort", 4, ErrorAbort, map[string]bool{"data/0/0.log": true}, 4, true, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &gauge{calls: make(map[string]int), fail: tt.fail}
+			c := NewCrawler(log.NewNop(), g.action).
+				WithFilesystem(fs).
+				WithConcurrency(tt.workers).
+				WithErrorPolicy(tt.policy)
+			erc := make(chan error)
+			go c.Run(context.Background(), "data", erc)
+			err := <-erc
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Crawler.Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if g.maximum > tt.wantMaximum || (tt.wantAll && g.maximum != tt.wantMaximum) {
+				t.Errorf("Crawler.Run() handled %d files at once, want %d", g.maximum, tt.wantMaximum)
+			}
+			for _, name := range files {
+				if g.calls[name] > 1 || (tt.wantAll && g.calls[name] != 1) {
+					t.Errorf("Crawler.Run() handled %s %d times", name, g.calls[name])
+				}
+			}
+			if !tt.wantAll && len(g.calls) == len(files) {
+				t.Errorf("Crawler.Run() did not abort")
+			}
+			if tt.wantFailed > 0 {
+				cerr, ok := err.(*CrawlError)
+				if !ok || len(cerr.Failures) != tt.wantFailed || cerr.Failures[0].Path != "data/0/0.log" {
+					t.Errorf("Crawler.Run() error = %v, want %d sorted failures", err, tt.wantFailed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package fscrawl
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy defines how the crawler treats symlinks
+type SymlinkPolicy int
+
+const (
+	// SymlinkSkip ignores symlinks entirely
+	SymlinkSkip SymlinkPolicy = iota
+	// SymlinkFollow crawls the targets of symlinks, dirs reachable on multiple ways are only crawled once
+	SymlinkFollow
+	// SymlinkSameDevice follows symlinks only if their target is on the device of the crawled dir
+	SymlinkSameDevice
+)
+
+// ParseSymlinkPolicy from its flag representation
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch s {
+	case "skip", "":
+		return SymlinkSkip, nil
+	case "follow":
+		return SymlinkFollow, nil
+	case "same-device":
+		return SymlinkSameDevice, nil
+	}
+	return SymlinkSkip, fmt.Errorf("unsupported symlink policy %q", s)
+}
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkFollow:
+		return "follow"
+	case SymlinkSameDevice:
+		return "same-device"
+	}
+	return "skip"
+}
+
+// Rules limit which paths the crawler hands to its actions
+// The zero value crawls everything except symlinks
+type Rules struct {
+	// Include globs a file has to match, dirs are always crawled
+	// Globs without a slash match the base name, others the slash separated path relative to the crawled dir
+	Include []string
+	// Exclude globs of files and dirs to skip, matched like Include
+	Exclude []string
+	// MaxDepth limits how deep the crawler descends, 1 only crawls the entries of the dir itself, 0 is unlimited
+	MaxDepth int
+	// MaxSize in bytes of files to handle, 0 is unlimited
+	MaxSize int64
+	// SkipHidden ignores files and dirs whose name starts with a dot
+	SkipHidden bool
+	// Symlinks decides whether symlinks are followed
+	Symlinks SymlinkPolicy
+	// OneFilesystem does not descend into dirs on other devices, like mount points
+	OneFilesystem bool
+}
+
+// Validate all globs of the rules
+func (r Rules) Validate() error {
+	for _, pattern := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+	}
+	if r.MaxDepth < 0 {
+		return fmt.Errorf("invalid max depth %d", r.MaxDepth)
+	}
+	if r.MaxSize < 0 {
+		return fmt.Errorf("invalid max size %d", r.MaxSize)
+	}
+	return nil
+}
+
+// skip returns the reason for skipping the entry at rel, which is relative to the crawled dir
+// An empty reason means the entry gets crawled
+func (r Rules) skip(rel string, info os.FileInfo) string {
+	if r.SkipHidden && strings.HasPrefix(path.Base(rel), ".") {
+		return "hidden"
+	}
+	if match(r.Exclude, rel) {
+		return "excluded"
+	}
+	if info.IsDir() {
+		return ""
+	}
+	if len(r.Include) > 0 && !match(r.Include, rel) {
+		return "not-included"
+	}
+	if r.MaxSize > 0 && info.Size() > r.MaxSize {
+		return "size"
+	}
+	return ""
+}
+
+// descend reports whether the entries of a dir at depth get crawled
+func (r Rules) descend(depth int) bool {
+	return r.MaxDepth == 0 || depth < r.MaxDepth
+}
+
+func match(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// relative returns the slash separated path of name relative to root
+func relative(root, name string) string {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return filepath.ToSlash(name)
+	}
+	return filepath.ToSlash(rel)
+}
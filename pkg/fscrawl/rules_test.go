@@ -0,0 +1,231 @@
+package fscrawl
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, p := range []SymlinkPolicy{SymlinkSkip, SymlinkFollow, SymlinkSameDevice} {
+		got, err := ParseSymlinkPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseSymlinkPolicy(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseSymlinkPolicy("always"); err == nil {
+		t.Errorf("ParseSymlinkPolicy() error = nil for unsupported policy")
+	}
+}
+
+func TestRules_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		wantErr bool
+	}{
+		{"zero", Rules{}, false},
+		{"globs", Rules{Include: []string{"*.log"}, Exclude: []string{"/cache/*", ".git"}}, false},
+		{"badGlob", Rules{Exclude: []string{"[a-"}}, true},
+		{"negativeDepth", Rules{MaxDepth: -1}, true},
+		{"negativeSize", Rules{MaxSize: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Rules.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// recorder is an action remembering the paths it was called for
+type recorder struct {
+	paths []string
+}
+
+func (r *recorder) action(ctx context.Context, path string, file os.FileInfo) error {
+	r.paths = append(r.paths, filepath.ToSlash(path))
+	return nil
+}
+
+func crawl(t *testing.T, c *Crawler, dir string) {
+	erc := make(chan error)
+	go c.Run(context.Background(), model.Directory(dir), erc)
+	if err := <-erc; err != nil {
+		t.Fatalf("Crawler.Run() error = %v", err)
+	}
+}
+
+func TestCrawler_Rules(t *testing.T) {
+	fs := vfs.NewMem()
+	files := map[string]int{
+		"data/a.log":              1,
+		"data/b.txt":              1,
+		"data/big.log":            100,
+		"data/.hidden.log":        1,
+		"data/.git/config":        1,
+		"data/cache/c.log":        1,
+		"data/sub/d.log":          1,
+		"data/sub/deep/e.log":     1,
+		"data/sub/cache/keep.log": 1,
+	}
+	for name, size := range files {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(name, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		rules Rules
+		want  []string
+	}{
+		{
+			"zero",
+			Rules{},
+			[]string{"data", "data/.git", "data/.git/config", "data/.hidden.log", "data/a.log", "data/b.txt", "data/big.log",
+				"data/cache", "data/cache/c.log", "data/sub", "data/sub/cache", "data/sub/cache/keep.log",
+				"data/sub/d.log", "data/sub/deep", "data/sub/deep/e.log"},
+		},
+		{
+			"includeExclude",
+			Rules{Include: []string{"*.log"}, Exclude: []string{"/cache", "deep", ".*"}},
+			[]string{"data", "data/a.log", "data/big.log", "data/sub", "data/sub/cache", "data/sub/cache/keep.log", "data/sub/d.log"},
+		},
+		{
+			"hiddenDepthSize",
+			Rules{SkipHidden: true, MaxDepth: 2, MaxSize: 10},
+			[]string{"data", "data/a.log", "data/b.txt", "data/cache", "data/cache/c.log", "data/sub",
+				"data/sub/cache", "data/sub/d.log", "data/sub/deep"},
+		},
+		{
+			"nestedGlob",
+			Rules{Include: []string{"sub/*/*.log"}},
+			[]string{"data", "data/.git", "data/cache", "data/sub", "data/sub/cache", "data/sub/cache/keep.log",
+				"data/sub/deep", "data/sub/deep/e.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			crawl(t, NewCrawler(log.NewNop(), r.action).WithFilesystem(fs).WithRules(tt.rules), "data")
+			if !reflect.DeepEqual(r.paths, tt.want) {
+				t.Errorf("Crawler.Run() handled %v, want %v", r.paths, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrawler_IgnoreFiles(t *testing.T) {
+	fs := vfs.NewMem()
+	files := map[string]string{
+		"data/.fscrubignore":     "*.tmp\n/cache/\n",
+		"data/a.log":             "",
+		"data/a.tmp":             "",
+		"data/cache/b.log":       "",
+		"data/sub/.fscrubignore": "!keep.tmp\n",
+		"data/sub/keep.tmp":      "",
+		"data/sub/drop.tmp":      "",
+	}
+	for name, content := range files {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &recorder{}
+	c := NewCrawler(log.NewNop(), r.action).WithFilesystem(fs)
+	crawl(t, c, "data")
+	want := []string{"data", "data/.fscrubignore", "data/a.log", "data/sub", "data/sub/.fscrubignore", "data/sub/keep.tmp"}
+	if !reflect.DeepEqual(r.paths, want) {
+		t.Errorf("Crawler.Run() handled %v, want %v", r.paths, want)
+	}
+
+	// ignore files are read again by the next crawl
+	if err := fs.WriteFile("data/.fscrubignore", []byte("sub/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.paths = nil
+	crawl(t, c, "data")
+	want = []string{"data", "data/.fscrubignore", "data/a.log", "data/a.tmp", "data/cache", "data/cache/b.log"}
+	if !reflect.DeepEqual(r.paths, want) {
+		t.Errorf("Crawler.Run() after change handled %v, want %v", r.paths, want)
+	}
+
+	// once disabled, ignore files are handled like any other file
+	r.paths = nil
+	crawl(t, c.WithIgnoreFiles(false), "data")
+	want = []string{"data", "data/.fscrubignore", "data/a.log", "data/a.tmp", "data/cache", "data/cache/b.log",
+		"data/sub", "data/sub/.fscrubignore", "data/sub/drop.tmp", "data/sub/keep.tmp"}
+	if !reflect.DeepEqual(r.paths, want) {
+		t.Errorf("Crawler.Run() without ignore files handled %v, want %v", r.paths, want)
+	}
+}
+
+func TestCrawler_Symlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fscrawlTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, outside := filepath.Join(dir, "data"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(data, "sub"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{filepath.Join(data, "sub", "a.log"), filepath.Join(outside, "b.log")} {
+		if err := ioutil.WriteFile(name, []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(data, "sub", "loop"): data,
+		filepath.Join(data, "outside"):     outside,
+		filepath.Join(data, "file.log"):    filepath.Join(outside, "b.log"),
+		filepath.Join(data, "broken.log"):  filepath.Join(dir, "missing"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy SymlinkPolicy
+		want   []string
+	}{
+		{"skip", SymlinkSkip, []string{".", "sub", "sub/a.log"}},
+		{"follow", SymlinkFollow, []string{".", "file.log", "outside", "outside/b.log", "sub", "sub/a.log"}},
+		{"sameDevice", SymlinkSameDevice, []string{".", "file.log", "outside", "outside/b.log", "sub", "sub/a.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			crawl(t, NewCrawler(log.NewNop(), r.action).WithRules(Rules{Symlinks: tt.policy}), data)
+			var got []string
+			for _, p := range r.paths {
+				got = append(got, relative(data, p))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Crawler.Run() handled %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package fscrawl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/playnet-public/fscrub/pkg/fsignore"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"go.uber.org/zap"
+)
+
+// fileID identifies a file independent of the path leading to it
+type fileID struct {
+	dev, ino uint64
+}
+
+// walker crawls a single dir according to the rules of its crawler
+// Like vfs.Walk it visits entries in lexical order
+type walker struct {
+	c    *Crawler
+	ctx  context.Context
+	root string
+	// rootID is the identity of the crawled dir, if the platform supports it
+	rootID     fileID
+	identified bool
+	// visited dirs, only tracked while following symlinks
+	visited map[fileID]bool
+	// ignore files are read again on every crawl, nil if they are not honored
+	ignore *fsignore.Matcher
+	// failures skipped according to the error policy
+	m        sync.Mutex
+	failures []Failure
+	// pool handles files concurrently, nil if they are handled sequentially
+	pool *pool
+}
+
+// crawl root, following it if it is a symlink itself
+func (c *Crawler) crawl(ctx context.Context, root string) error {
+	w := &walker{
+		c:       c,
+		ctx:     ctx,
+		root:    root,
+		visited: make(map[fileID]bool),
+	}
+	if c.ignoreFiles {
+		w.ignore = fsignore.NewMatcher(c.log, c.fs)
+	}
+	info, err := c.fs.Lstat(root)
+	if err == nil && isSymlink(info) {
+		info, err = c.fs.Stat(root)
+	}
+	if err != nil {
+		return w.result(w.handle(root, nil, err))
+	}
+	w.rootID, w.identified = identify(info)
+	if c.concurrency > 1 {
+		w.start(c.concurrency)
+	}
+	err = w.finish(w.walk(root, info, 0))
+	if err == filepath.SkipDir {
+		err = nil
+	}
+	return w.result(err)
+}
+
+func (w *walker) walk(path string, info os.FileInfo, depth int) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if depth > 0 {
+		if isSymlink(info) {
+			target, reason := w.follow(path)
+			if reason != "" {
+				return w.skip(path, reason)
+			}
+			info = target
+		}
+		if reason := w.c.rules.skip(relative(w.root, path), info); reason != "" {
+			return w.skip(path, reason)
+		}
+		if reason := w.boundary(info); reason != "" {
+			return w.skip(path, reason)
+		}
+		if w.ignore.Ignored(w.root, path, info.IsDir()) {
+			return w.skip(path, "ignore-file")
+		}
+		if vfs.IsTempFile(path) {
+			return w.skip(path, "temp-file")
+		}
+	}
+	if !info.IsDir() {
+		return w.file(path, info)
+	}
+	if w.c.rules.Symlinks != SymlinkSkip {
+		if id, ok := identify(info); ok {
+			if w.visited[id] {
+				return w.skip(path, "visited")
+			}
+			w.visited[id] = true
+		}
+	}
+
+	entries, err := w.c.fs.ReadDir(path)
+	err1 := w.handle(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	if !w.c.rules.descend(depth) {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		err = w.walk(filepath.Join(path, entry.Name()), entry, depth+1)
+		if err != nil {
+			if !entry.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// follow the symlink at path according to the symlink policy
+// A non-empty reason is returned if the symlink should not be followed
+func (w *walker) follow(path string) (os.FileInfo, string) {
+	policy := w.c.rules.Symlinks
+	if policy == SymlinkSkip {
+		return nil, "symlink"
+	}
+	target, err := w.c.fs.Stat(path)
+	if err != nil {
+		w.c.log.Debug("unable to follow symlink", zap.String("path", path), zap.Error(err))
+		return nil, "broken-symlink"
+	}
+	id, ok := identify(target)
+	if policy == SymlinkSameDevice && (!ok || !w.identified || id.dev != w.rootID.dev) {
+		return nil, "symlink-device"
+	}
+	// without file identities loops can not be detected
+	if target.IsDir() && !ok {
+		return nil, "symlink-loop"
+	}
+	return target, ""
+}
+
+// boundary returns a reason if info lies on another device than the crawled dir and filesystems must not be crossed
+func (w *walker) boundary(info os.FileInfo) string {
+	if !w.c.rules.OneFilesystem || !w.identified {
+		return ""
+	}
+	if id, ok := identify(info); ok && id.dev != w.rootID.dev {
+		return "filesystem"
+	}
+	return ""
+}
+
+func (w *walker) skip(path, reason string) error {
+	w.c.log.Debug("skipping path", zap.String("path", path), zap.String("reason", reason))
+	return nil
+}
+
+func isSymlink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
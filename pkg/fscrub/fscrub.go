@@ -2,39 +2,107 @@ package fscrub
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
-	"github.com/playnet-public/libs/log"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/playnet-public/libs/log"
 
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
+	"github.com/playnet-public/fscrub/pkg/fsreport"
 	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
 	"go.uber.org/zap"
 )
 
 // Fscrub defines an action for scrubbing text files
 type Fscrub struct {
-	patterns Patterns
-	dry      bool
+	patterns   Patterns
+	dry        bool
+	textPolicy TextPolicy
+	// fingerprint of patterns recorded in headers
+	fingerprint string
+	// outdatedOnly limits Handle to files scrubbed with another pattern set
+	outdatedOnly bool
+	// policies select the patterns and action per file
+	policies   *fspolicy.Resolver
+	m          sync.Mutex
+	selections map[string]selection
 
 	log         *log.Logger
-	fileOpener  func(path string) (*os.File, error)
+	fileOpener  func(path string) (io.ReadCloser, error)
 	fileWriter  func(path string, data []byte) error
 	fileUpdater func(path, content string) error
+	fileDiffer  func(path string, old, new []string) error
+	reporter    fsreport.Reporter
+	stats       stats
 }
 
+// headerPeekSize is the amount of content checked for a header recording the pattern set
+const headerPeekSize = 4096
+
+// previewLength limits the length of the redacted line stored with findings
+const previewLength = 160
+
 // NewFscrub with logger
 func NewFscrub(log *log.Logger, dryrun bool, patterns ...Pattern) *Fscrub {
 	f := &Fscrub{
-		patterns: patterns,
-		log:      log,
-		dry:      dryrun,
+		patterns:    patterns,
+		log:         log,
+		dry:         dryrun,
+		fingerprint: Fingerprint(patterns),
 	}
-	f.fileOpener = primitives.OpenFile(log)
-	f.fileWriter = primitives.WriteFile(log)
+	f.fileOpener = primitives.OpenFile(vfs.OS{})
+	f.fileWriter = primitives.WriteFile(vfs.OS{})
 	f.fileUpdater = FileUpdater(f)
+	f.fileDiffer = DiffPrinter(f, os.Stdout)
+	return f
+}
+
+// WithFilesystem reads and writes all files on fs
+func (f *Fscrub) WithFilesystem(fs vfs.Filesystem) *Fscrub {
+	f.fileOpener = primitives.OpenFile(fs)
+	f.fileWriter = primitives.WriteFile(fs)
 	return f
 }
 
+// WithDiffer replaces the function receiving the changes computed during dry runs
+func (f *Fscrub) WithDiffer(differ func(path string, old, new []string) error) *Fscrub {
+	f.fileDiffer = differ
+	return f
+}
+
+// WithReporter records all findings to r
+func (f *Fscrub) WithReporter(r fsreport.Reporter) *Fscrub {
+	f.reporter = r
+	return f
+}
+
+// WithOutdatedOnly limits handling to files whose header shows they were scrubbed with another pattern set
+// All other files, including those never changed by fscrub, are skipped
+func (f *Fscrub) WithOutdatedOnly(outdatedOnly bool) *Fscrub {
+	f.outdatedOnly = outdatedOnly
+	return f
+}
+
+// Fingerprint of the pattern set recorded in the header of scrubbed files
+func (f *Fscrub) Fingerprint() string {
+	return f.fingerprint
+}
+
+// Summary returns the statistics of all files handled so far
+func (f *Fscrub) Summary() Summary {
+	return f.stats.get()
+}
+
 // Validate that fscrub has fileOpener
 func (f *Fscrub) Validate() error {
 	if f.fileOpener == nil {
@@ -44,12 +112,20 @@ func (f *Fscrub) Validate() error {
 }
 
 // Handle path and take actions if fileInfo matches required criteria
-func (f *Fscrub) Handle(path string, fileInfo os.FileInfo) error {
+// If ctx is done while the file is scanned it is left unchanged, once it is being written the write is finished
+func (f *Fscrub) Handle(ctx context.Context, path string, fileInfo os.FileInfo) (err error) {
 
 	if fileInfo.IsDir() {
 		return nil
 	}
 
+	changed, skipped := false, false
+	defer func() {
+		if !skipped {
+			f.stats.file(changed, err)
+		}
+	}()
+
 	f.log.Info(
 		"running action",
 		zap.String("action", "fscrub"),
@@ -57,92 +133,109 @@ func (f *Fscrub) Handle(path string, fileInfo os.FileInfo) error {
 		zap.String("file", fileInfo.Name()),
 	)
 
-	changed := false
-	var newFile []string
-	{
-		file, err := f.fileOpener(path)
-		defer file.Close()
-		if err != nil {
-			if os.IsNotExist(err) {
-				f.log.Error("file does not exist",
-					zap.String("file", path),
-					zap.Error(err))
-				return err
-			}
-			if os.IsPermission(err) {
-				f.log.Error("file permission denied",
-					zap.String("file", path),
-					zap.Error(err))
-				return err
-			}
-			f.log.Error("undefined file error",
+	if f.policies != nil && fspolicy.IsPolicyFile(path) {
+		f.log.Info("reloading policy", zap.String("file", path))
+		f.policies.Invalidate(filepath.Dir(path))
+		skipped = true
+		return nil
+	}
+	sc, err := f.scopeOf(path)
+	if err != nil {
+		f.log.Error("resolving policy failed", zap.String("file", path), zap.Error(err))
+		return err
+	}
+	if sc == nil {
+		f.log.Debug("skipping file by policy", zap.String("file", path))
+		skipped = true
+		return nil
+	}
+
+	file, err := f.fileOpener(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			f.log.Error("file does not exist",
+				zap.String("file", path),
+				zap.Error(err))
+			return err
+		}
+		if os.IsPermission(err) {
+			f.log.Error("file permission denied",
 				zap.String("file", path),
 				zap.Error(err))
 			return err
 		}
+		f.log.Error("undefined file error",
+			zap.String("file", path),
+			zap.Error(err))
+		return err
+	}
+	defer file.Close()
 
-		f.log.Info("file scan started", zap.String("file", path))
-		scanner := bufio.NewScanner(file)
-		lineNo := 0
-		for scanner.Scan() {
-			line := Line{
-				Path:    path,
-				No:      lineNo,
-				Text:    scanner.Text(),
-				Changed: false,
-			}
-			if line.Text == primitives.BuildIgnoreHeader() {
-				f.log.Info(
-					"skipping file",
-					zap.String("action", "fscrub"),
-					zap.String("path", path),
-					zap.String("reason", "skip-header"),
-				)
-				return nil
-			}
-			skipLine := false
-			for _, hl := range primitives.BuildHeader() {
-				if line.Text == hl {
-					skipLine = true
-				}
-			}
-			if skipLine {
-				continue
-			}
-			new, err := f.HandleLine(line)
-			if err != nil {
-				f.log.Error("failed handling line",
-					zap.String("file", path),
-					zap.Int("line", lineNo),
-					zap.String("text", line.Text),
-					zap.Error(err))
-				return err
-			}
-			if new.Changed {
-				changed = true
-				line = new
-			}
-			lineNo = lineNo + 1
-			newFile = append(newFile, line.Text)
+	in := bufio.NewReaderSize(file, headerPeekSize)
+	if f.outdatedOnly {
+		head, _ := in.Peek(headerPeekSize)
+		fingerprint, found := headerPatternSet(head)
+		if !found || fingerprint == sc.fingerprint {
+			f.log.Debug("skipping file not scrubbed with an outdated pattern set", zap.String("file", path))
+			skipped = true
+			return nil
 		}
-		err = scanner.Err()
-		if err == nil {
-			f.log.Info("file scan finished", zap.String("file", path))
-		} else {
-			f.log.Error("file scan failed", zap.String("file", path), zap.Error(err))
-			return err
+		f.log.Info("rescrubbing file scrubbed with an outdated pattern set",
+			zap.String("file", path),
+			zap.String("patterns", fingerprint),
+		)
+	}
+
+	f.log.Info("file scan started", zap.String("file", path))
+	var original, scrubbed bytes.Buffer
+	res, err := f.scrub(ctx, io.TeeReader(in, &original), &scrubbed, Options{Name: path, Header: sc.header}, sc, false)
+	// findings are reported once it is known whether they got replaced
+	defer func() {
+		action := f.action(sc, false)
+		if err != nil {
+			action = fsreport.ActionFailed
+		}
+		if rerr := f.emit(res.findings, action); rerr != nil {
+			f.log.Error("reporting findings failed", zap.String("file", path), zap.Error(rerr))
+			if err == nil {
+				err = rerr
+			}
 		}
+	}()
+	if err != nil && ctx.Err() != nil {
+		f.log.Info("file scan cancelled, leaving file unchanged", zap.String("file", path))
+		return err
+	}
+	if err != nil {
+		f.log.Error("file scan failed", zap.String("file", path), zap.Error(err))
+		return err
 	}
+	f.log.Info("file scan finished", zap.String("file", path))
+	changed = res.Changed
 
-	if changed {
-		newFile = append(primitives.BuildHeader(), newFile...)
-		err := f.fileUpdater(path, strings.Join(newFile, "\n"))
+	if !changed && !res.Refreshed {
+		return nil
+	}
+	if sc.report && !f.dry {
+		f.log.Info("leaving file unchanged by policy", zap.String("file", path))
+		return nil
+	}
+	if f.dry {
+		err := f.fileDiffer(path, splitLines(original.String()), splitLines(scrubbed.String()))
 		if err != nil {
-			f.log.Error("updating file failed",
+			f.log.Error("reporting changes failed",
 				zap.String("file", path),
 				zap.Error(err))
 			return err
 		}
+		return nil
+	}
+	err = f.fileUpdater(path, scrubbed.String())
+	if err != nil {
+		f.log.Error("updating file failed",
+			zap.String("file", path),
+			zap.Error(err))
+		return err
 	}
 
 	return nil
@@ -157,55 +250,132 @@ type Line struct {
 }
 
 // HandleLine and return new line or error
-func (f *Fscrub) HandleLine(line Line) (Line, error) {
+// The replacement is computed in dry runs as well, leaving it to Handle not to persist it
+func (f *Fscrub) HandleLine(ctx context.Context, line Line) (Line, error) {
+	sc := f.defaultScope()
+	new, findings, err := f.handleLine(ctx, line, sc, nil)
+	if err != nil {
+		return new, err
+	}
+	return new, f.emit(findings, f.action(sc, false))
+}
+
+// handleLine applies the patterns of sc, counting all findings into res if not nil
+// The findings to report are located in the original line, it is up to the caller to emit them once the line is persisted
+func (f *Fscrub) handleLine(ctx context.Context, line Line, sc *scope, res *Result) (Line, []fsreport.Finding, error) {
+	original := line.Text
+	var findings []fsreport.Finding
+	var spans [][]int
 	//f.log.Debug("handling line",
 	//	zap.String("file", line.Path),
 	//	zap.Int("line", line.No),
 	//	zap.String("text", line.Text))
 
-	for _, p := range f.patterns {
-		count, err := p.Find(line.Text, line.Path)
+	for _, p := range sc.patterns {
+		count, err := p.Find(ctx, line.Text, line.Path)
 		if err != nil {
 			f.log.Error("finding pattern failed",
 				zap.String("file", line.Path),
 				zap.Int("line", line.No),
-				zap.String("text", line.Text),
-				zap.String("pattern", p.String()),
+				f.textField(line),
+				f.patternField(p),
 				zap.Error(err),
 			)
-			return line, err
+			return line, nil, err
 		}
 		if count > 0 {
+			f.stats.finding(PatternSeverity(p), count)
+			if res != nil {
+				res.Findings[PatternID(p)] = res.Findings[PatternID(p)] + count
+			}
 			f.log.Info("found pattern",
 				zap.String("file", line.Path),
 				zap.Int("line", line.No),
-				zap.String("text", line.Text),
-				zap.String("pattern", p.String()),
+				f.textField(line),
+				f.patternField(p),
 			)
-			f.log.Info("handling pattern",
-				zap.String("file", line.Path),
-				zap.Int("line", line.No),
-				zap.String("text", line.Text),
-				zap.String("pattern", p.String()),
-			)
-			if !f.dry {
-				new, err := p.Handle(line.Text, line.Path)
+			if f.reporter != nil {
+				located, err := Locate(ctx, p, original, line.Path)
 				if err != nil {
-					f.log.Error("handling pattern failed",
+					f.log.Error("locating finding failed",
 						zap.String("file", line.Path),
 						zap.Int("line", line.No),
-						zap.String("text", line.Text),
-						zap.String("pattern", p.String()),
+						f.patternField(p),
 						zap.Error(err),
 					)
-					return line, err
+					return line, nil, err
 				}
-				line.Text = new
-				line.Changed = true
+				spans = append(spans, located...)
+				findings = append(findings, findingsOf(line, original, p, located)...)
 			}
+			f.log.Info("handling pattern",
+				zap.String("file", line.Path),
+				zap.Int("line", line.No),
+				f.textField(line),
+				f.patternField(p),
+			)
+			new, err := p.Handle(ctx, line.Text, line.Path)
+			if err != nil {
+				f.log.Error("handling pattern failed",
+					zap.String("file", line.Path),
+					zap.Int("line", line.No),
+					f.textField(line),
+					f.patternField(p),
+					zap.Error(err),
+				)
+				return line, nil, err
+			}
+			line.Text = new
+			line.Changed = true
+		}
+	}
+	// the preview hides the findings of all patterns, not just the one reported
+	preview := primitives.Redact(original, spans)
+	if utf8.RuneCountInString(preview) > previewLength {
+		preview = string([]rune(preview)[:previewLength]) + "..."
+	}
+	for i := range findings {
+		findings[i].Preview = preview
+	}
+	return line, findings, nil
+}
+
+// findingsOf returns a finding for every span of p inside the original text of line
+func findingsOf(line Line, original string, p Pattern, spans [][]int) []fsreport.Finding {
+	findings := make([]fsreport.Finding, len(spans))
+	for i, span := range spans {
+		findings[i] = fsreport.Finding{
+			File:        line.Path,
+			Line:        line.No + 1,
+			StartColumn: utf8.RuneCountInString(original[:span[0]]) + 1,
+			EndColumn:   utf8.RuneCountInString(original[:span[1]]) + 1,
+			Rule:        PatternID(p),
+			Severity:    PatternSeverity(p).String(),
+		}
+	}
+	return findings
+}
+
+// action taken on the findings within sc, passthrough content is written unchanged
+func (f *Fscrub) action(sc *scope, passthrough bool) string {
+	if f.dry || sc.report || passthrough {
+		return fsreport.ActionDetected
+	}
+	return fsreport.ActionReplaced
+}
+
+// emit findings to the reporter, recording action as taken on them
+func (f *Fscrub) emit(findings []fsreport.Finding, action string) error {
+	if f.reporter == nil {
+		return nil
+	}
+	for _, finding := range findings {
+		finding.Action = action
+		if err := f.reporter.Report(finding); err != nil {
+			return err
 		}
 	}
-	return line, nil
+	return nil
 }
 
 // FileUpdater returns update function for files
@@ -223,3 +393,49 @@ func FileUpdater(f *Fscrub) func(path, content string) error {
 		return nil
 	}
 }
+
+// DiscardDiff is a differ ignoring all changes, used when only findings are of interest
+func DiscardDiff(path string, old, new []string) error {
+	return nil
+}
+
+// DiffPrinter returns a differ writing unified diffs to w
+func DiffPrinter(f *Fscrub, w io.Writer) func(path string, old, new []string) error {
+	var m sync.Mutex
+	return func(path string, old, new []string) error {
+		diff := primitives.UnifiedDiff(path, path, old, new, primitives.DiffContext)
+		if diff == "" {
+			return nil
+		}
+		m.Lock()
+		defer m.Unlock()
+		_, err := fmt.Fprint(w, diff)
+		if err != nil {
+			return err
+		}
+		f.log.Info("printed changes", zap.String("file", path))
+		return nil
+	}
+}
+
+// DiffPatcher returns a differ writing unified diffs as .patch files below dir
+// The path of the scrubbed file is preserved inside dir
+func DiffPatcher(f *Fscrub, dir string) func(path string, old, new []string) error {
+	return func(path string, old, new []string) error {
+		diff := primitives.UnifiedDiff(path, path, old, new, primitives.DiffContext)
+		if diff == "" {
+			return nil
+		}
+		patch := filepath.Join(dir, strings.TrimPrefix(path, filepath.VolumeName(path))) + ".patch"
+		err := os.MkdirAll(filepath.Dir(patch), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(patch, []byte(diff), 0644)
+		if err != nil {
+			return err
+		}
+		f.log.Info("wrote patch", zap.String("file", path), zap.String("patch", patch))
+		return nil
+	}
+}
@@ -1,8 +1,12 @@
 package fscrub
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -12,7 +16,9 @@ import (
 
 	"github.com/playnet-public/libs/log"
 
+	"github.com/playnet-public/fscrub/pkg/fsreport"
 	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
 )
 
 func TestNewFscrub(t *testing.T) {
@@ -46,10 +52,10 @@ func TestNewFscrub(t *testing.T) {
 func TestFscrub_Handle(t *testing.T) {
 	log := log.NewNop()
 	patterns := Patterns{
-		&StringPattern{"foo", "bar"},
+		&StringPattern{Source: "foo", Target: "bar"},
 	}
 	errPatterns := Patterns{
-		&RegexPattern{"t\\s(*\\w+", nil, "bar"},
+		&RegexPattern{RegexString: "t\\s(*\\w+", Target: "bar"},
 	}
 	errFindPatterns := Patterns{
 		&mockErrFindPattern{},
@@ -71,7 +77,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"basic",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: FileUpdater(NewFscrub(log, false)),
 			},
@@ -82,7 +88,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"basicReplace",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    patterns,
@@ -94,7 +100,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"basicSkip",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    patterns,
@@ -106,7 +112,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"headerSkip",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    patterns,
@@ -118,7 +124,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"handleErr",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    errPatterns,
@@ -130,7 +136,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"handleFindErr",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    errFindPatterns,
@@ -142,7 +148,7 @@ func TestFscrub_Handle(t *testing.T) {
 		{
 			"handleHandleErr",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("testdata.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    errHandlePatterns,
@@ -206,10 +212,38 @@ func TestFscrub_Handle(t *testing.T) {
 			args{"undefErr.txt", newMockFileInfo(false)},
 			true,
 		},
+		{
+			"dryRun",
+			&Fscrub{log: log,
+				dry:         true,
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
+				fileWriter:  mockWriteFile("testdata.txt"),
+				fileUpdater: mockUpdateFile,
+				fileDiffer:  mockDiffFile,
+				patterns:    patterns,
+			},
+			"foo\nbar\nfoo\n",
+			args{"testdata.txt", newMockFileInfo(false)},
+			false,
+		},
+		{
+			"failDiff",
+			&Fscrub{log: log,
+				dry:         true,
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
+				fileWriter:  mockWriteFile("faildiff.txt"),
+				fileUpdater: mockUpdateFile,
+				fileDiffer:  mockDiffFile,
+				patterns:    patterns,
+			},
+			"foo\nbar\nfoo\n",
+			args{"faildiff.txt", newMockFileInfo(false)},
+			true,
+		},
 		{
 			"failUpdate",
 			&Fscrub{log: log,
-				fileOpener:  primitives.OpenFile(log),
+				fileOpener:  primitives.OpenFile(vfs.OS{}),
 				fileWriter:  mockWriteFile("failupdate.txt"),
 				fileUpdater: mockUpdateFile,
 				patterns:    patterns,
@@ -235,9 +269,15 @@ func TestFscrub_Handle(t *testing.T) {
 				t.Errorf("Fscrub.UpdateFile() newContent = %s, want %v", data, tt.content)
 			}
 
-			if err := tt.f.Handle(path, tt.args.fileInfo); (err != nil) != tt.wantErr {
+			if err := tt.f.Handle(context.Background(), path, tt.args.fileInfo); (err != nil) != tt.wantErr {
 				t.Errorf("Fscrub.Handle() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.f.dry {
+				data, err := ioutil.ReadFile(path)
+				if err != nil || string(data) != tt.content {
+					t.Errorf("Fscrub.Handle() changed file in dry run to %q, %v", data, err)
+				}
+			}
 		})
 	}
 }
@@ -245,11 +285,11 @@ func TestFscrub_Handle(t *testing.T) {
 func TestFscrub_HandleLine(t *testing.T) {
 	log := log.NewNop()
 	patterns := Patterns{
-		&StringPattern{"foo", "bar"},
+		&StringPattern{Source: "foo", Target: "bar"},
 	}
 	errPatterns := Patterns{
 		NewRegexPattern("t\\s(*\\w+", "bar"),
-		&RegexPattern{"foo", nil, "bar"},
+		&RegexPattern{RegexString: "foo", Target: "bar"},
 	}
 	tests := []struct {
 		name    string
@@ -269,7 +309,7 @@ func TestFscrub_HandleLine(t *testing.T) {
 			"findFoo",
 			NewFscrub(log, true, patterns...),
 			Line{"testfile.txt", 0, "foo", false},
-			"foo",
+			"bar",
 			false,
 		},
 		{
@@ -289,7 +329,7 @@ func TestFscrub_HandleLine(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.f.HandleLine(tt.line)
+			got, err := tt.f.HandleLine(context.Background(), tt.line)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Fscrub.HandleLine() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -301,6 +341,91 @@ func TestFscrub_HandleLine(t *testing.T) {
 	}
 }
 
+func TestFscrub_Report(t *testing.T) {
+	log := log.NewNop()
+	var buf bytes.Buffer
+	f := NewFscrub(log, false, NewStringPattern("secret", "***")).WithReporter(fsreport.NewJSONLines(&buf))
+	if _, err := f.HandleLine(context.Background(), Line{"test.txt", 4, "pw: secret", false}); err != nil {
+		t.Fatalf("Fscrub.HandleLine() error = %v", err)
+	}
+	var got fsreport.Finding
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Fscrub reported invalid json: %v", err)
+	}
+	want := fsreport.Finding{
+		File:        "test.txt",
+		Line:        5,
+		StartColumn: 5,
+		EndColumn:   11,
+		Rule:        "string-e5e9fa1b",
+		Severity:    "medium",
+		Action:      fsreport.ActionReplaced,
+		Preview:     "pw: ***",
+	}
+	if got != want {
+		t.Errorf("Fscrub reported %+v, want %+v", got, want)
+	}
+}
+
+func TestFscrub_ReportHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		dry        bool
+		writeErr   error
+		wantAction string
+	}{
+		{"replaced", false, nil, fsreport.ActionReplaced},
+		{"writeFailed", false, errors.New("disk full"), fsreport.ActionFailed},
+		{"dryRun", true, nil, fsreport.ActionDetected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := vfs.NewMem()
+			if err := fs.WriteFile("a.txt", []byte("token secret\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := fs.Stat("a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			// the first pattern shortens the line, shifting the second finding
+			f := NewFscrub(log.NewNop(), tt.dry, NewStringPattern("token", "t"), NewStringPattern("secret", "***")).
+				WithFilesystem(fs).
+				WithReporter(fsreport.NewJSONLines(&buf)).
+				WithDiffer(DiscardDiff)
+			if tt.writeErr != nil {
+				f.fileUpdater = func(path, content string) error { return tt.writeErr }
+			}
+			if err := f.Handle(context.Background(), "a.txt", info); (err != nil) != (tt.writeErr != nil) {
+				t.Fatalf("Fscrub.Handle() error = %v, want %v", err, tt.writeErr)
+			}
+
+			var got []fsreport.Finding
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var finding fsreport.Finding
+				if err := dec.Decode(&finding); err != nil {
+					t.Fatalf("Fscrub reported invalid json: %v", err)
+				}
+				got = append(got, finding)
+			}
+			want := [][2]int{{1, 6}, {7, 13}}
+			if len(got) != len(want) {
+				t.Fatalf("Fscrub reported %d findings, want %d", len(got), len(want))
+			}
+			for i, finding := range got {
+				if finding.StartColumn != want[i][0] || finding.EndColumn != want[i][1] {
+					t.Errorf("finding %d at columns %d-%d, want %d-%d in the original line", i, finding.StartColumn, finding.EndColumn, want[i][0], want[i][1])
+				}
+				if finding.Action != tt.wantAction || finding.Preview != "*** ***" {
+					t.Errorf("finding %d = %+v, want action %s and all findings redacted", i, finding, tt.wantAction)
+				}
+			}
+		})
+	}
+}
+
 func TestFscrub_FileUpdater(t *testing.T) {
 	log := log.NewNop()
 	tests := []struct {
@@ -314,7 +439,7 @@ func TestFscrub_FileUpdater(t *testing.T) {
 			"basic",
 			&Fscrub{log: log,
 				fileOpener: mockOpenFile("testdata.txt", "ABC\nDEF\nGHI\n"),
-				fileWriter: primitives.WriteFile(log),
+				fileWriter: primitives.WriteFile(vfs.OS{}),
 			},
 			"testdata.txt",
 			"foo\nbar\n",
@@ -365,6 +490,162 @@ func TestFscrub_FileUpdater(t *testing.T) {
 	}
 }
 
+func TestFscrub_Differ(t *testing.T) {
+	log := log.NewNop()
+	old := []string{"foo", "abc"}
+	new := []string{"bar", "abc"}
+	want := "--- test.txt\n+++ test.txt\n@@ -1,2 +1,2 @@\n-foo\n+bar\n abc\n"
+
+	var buf bytes.Buffer
+	if err := DiffPrinter(NewFscrub(log, true), &buf)("test.txt", old, new); err != nil {
+		t.Errorf("Fscrub.DiffPrinter() error = %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("Fscrub.DiffPrinter() = %q, want %q", buf.String(), want)
+	}
+
+	dir, err := ioutil.TempDir("", "fscrubTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := DiffPatcher(NewFscrub(log, true), dir)("test.txt", old, new); err != nil {
+		t.Errorf("Fscrub.DiffPatcher() error = %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "test.txt.patch"))
+	if err != nil {
+		t.Errorf("Fscrub.DiffPatcher() error = %v when reading patch", err)
+	}
+	if string(data) != want {
+		t.Errorf("Fscrub.DiffPatcher() = %q, want %q", data, want)
+	}
+}
+
+func TestFscrub_DryRun(t *testing.T) {
+	content := "foo\nabc\nfoo\n"
+	dir, err := ioutil.TempDir("", "fscrubTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		differ func(f *Fscrub, buf *bytes.Buffer) func(path string, old, new []string) error
+		diff   func(buf *bytes.Buffer) (string, error)
+	}{
+		{
+			"printer",
+			func(f *Fscrub, buf *bytes.Buffer) func(path string, old, new []string) error { return DiffPrinter(f, buf) },
+			func(buf *bytes.Buffer) (string, error) { return buf.String(), nil },
+		},
+		{
+			"patcher",
+			func(f *Fscrub, buf *bytes.Buffer) func(path string, old, new []string) error { return DiffPatcher(f, dir) },
+			func(buf *bytes.Buffer) (string, error) {
+				data, err := ioutil.ReadFile(filepath.Join(dir, "a.txt.patch"))
+				return string(data), err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := vfs.NewMem()
+			if err := fs.WriteFile("a.txt", []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := fs.Stat("a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			f := NewFscrub(log.NewNop(), true, NewStringPattern("foo", "bar")).WithFilesystem(fs)
+			want := "--- a.txt\n+++ a.txt\n@@ -1,3 +1,9 @@\n-foo\n"
+			for _, line := range primitives.BuildHeaderWithPatternSet(f.Fingerprint()) {
+				want += "+" + line + "\n"
+			}
+			want += "+bar\n abc\n-foo\n+bar\n"
+			f.WithDiffer(tt.differ(f, &buf))
+			if err := f.Handle(context.Background(), "a.txt", info); err != nil {
+				t.Fatalf("Fscrub.Handle() error = %v", err)
+			}
+
+			diff, err := tt.diff(&buf)
+			if err != nil || diff != want {
+				t.Errorf("Fscrub.Handle() diff = %q, %v, want %q", diff, err, want)
+			}
+			data, err := vfs.ReadFile(fs, "a.txt")
+			if err != nil || string(data) != content {
+				t.Errorf("Fscrub.Handle() changed file in dry run to %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestFscrub_OutdatedOnly(t *testing.T) {
+	log := log.NewNop()
+	fs := vfs.NewMem()
+	f := NewFscrub(log, false, NewStringPattern("foo", "bar")).WithFilesystem(fs).WithOutdatedOnly(true)
+	header := func(fingerprint string) string {
+		return strings.Join(primitives.BuildHeaderWithPatternSet(fingerprint), "\n") + "\n"
+	}
+	files := map[string]struct {
+		content string
+		want    string
+	}{
+		"plain.txt":    {"foo\n", "foo\n"},
+		"current.txt":  {header(f.Fingerprint()) + "foo\n", header(f.Fingerprint()) + "foo\n"},
+		"outdated.txt": {header("0123456789abcdef") + "foo\nabc\n", header(f.Fingerprint()) + "bar\nabc\n"},
+		"legacy.txt":   {strings.Join(primitives.BuildHeader(), "\n") + "\nabc\n", header(f.Fingerprint()) + "abc\n"},
+	}
+	for name, file := range files {
+		if err := fs.WriteFile(name, []byte(file.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Handle(context.Background(), name, info); err != nil {
+			t.Errorf("Fscrub.Handle(%s) error = %v", name, err)
+		}
+	}
+	for name, file := range files {
+		data, err := vfs.ReadFile(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != file.want {
+			t.Errorf("Fscrub.Handle(%s) = %q, want %q", name, data, file.want)
+		}
+	}
+	if s := f.Summary(); s.Files != 2 || s.Changed != 1 {
+		t.Errorf("Fscrub.Handle() handled %v, want 2 files, 1 changed", s)
+	}
+}
+
+func TestFscrub_Cancel(t *testing.T) {
+	fs := vfs.NewMem()
+	content := strings.Repeat("foo\n", 100)
+	if err := fs.WriteFile("a.txt", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFscrub(log.NewNop(), false, NewStringPattern("foo", "bar")).WithFilesystem(fs)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.Handle(ctx, "a.txt", info); err != context.Canceled {
+		t.Errorf("Fscrub.Handle() error = %v, want %v", err, context.Canceled)
+	}
+	data, err := vfs.ReadFile(fs, "a.txt")
+	if err != nil || string(data) != content {
+		t.Errorf("Fscrub.Handle() changed cancelled file to %q, %v", data, err)
+	}
+}
+
 type mockFileInfo struct {
 	dir bool
 }
@@ -393,9 +674,9 @@ func newMockFileInfo(isDir bool) os.FileInfo {
 	return info
 }
 
-func mockOpenFile(path, content string) func(path string) (*os.File, error) {
+func mockOpenFile(path, content string) func(path string) (io.ReadCloser, error) {
 	byteSlice := []byte(content)
-	return func(path string) (*os.File, error) {
+	return func(path string) (io.ReadCloser, error) {
 		if strings.Contains(path, "notexist.txt") {
 			return nil, os.ErrNotExist
 		}
@@ -451,6 +732,13 @@ func mockUpdateFile(path, content string) error {
 	return nil
 }
 
+func mockDiffFile(path string, old, new []string) error {
+	if strings.Contains(path, "faildiff.txt") {
+		return errors.New("diff error")
+	}
+	return nil
+}
+
 func createTempFile(path string, content ...string) (*os.File, string, error) {
 
 	tmpDir, err := ioutil.TempDir("", "fscrubTests")
@@ -0,0 +1,82 @@
+package fscrub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// TextPolicy defines how the text of scrubbed lines appears in logs
+type TextPolicy int
+
+const (
+	// TextRedacted logs line text with all findings masked
+	TextRedacted TextPolicy = iota
+	// TextOmitted does not log line text at all
+	TextOmitted
+	// TextFull logs line text and patterns verbatim, leaking the data being scrubbed
+	// Only meant for debugging patterns
+	TextFull
+)
+
+// ParseTextPolicy from its flag representation
+func ParseTextPolicy(s string) (TextPolicy, error) {
+	switch s {
+	case "redacted", "":
+		return TextRedacted, nil
+	case "omit":
+		return TextOmitted, nil
+	case "full":
+		return TextFull, nil
+	}
+	return TextRedacted, fmt.Errorf("unsupported log text policy %q", s)
+}
+
+func (p TextPolicy) String() string {
+	switch p {
+	case TextOmitted:
+		return "omit"
+	case TextFull:
+		return "full"
+	}
+	return "redacted"
+}
+
+// WithTextPolicy sets how line text gets logged
+func (f *Fscrub) WithTextPolicy(p TextPolicy) *Fscrub {
+	f.textPolicy = p
+	return f
+}
+
+// textField returns the log field for line text according to the text policy
+// Redaction masks the findings of all patterns, or the whole line if locating them fails
+func (f *Fscrub) textField(line Line) zapcore.Field {
+	switch f.textPolicy {
+	case TextFull:
+		return zap.String("text", line.Text)
+	case TextOmitted:
+		return zap.Skip()
+	}
+	var spans [][]int
+	for _, p := range f.patterns {
+		// lines are logged even after the file got abandoned
+		found, err := Locate(context.Background(), p, line.Text, line.Path)
+		if err != nil {
+			return zap.String("text", primitives.RedactMask)
+		}
+		spans = append(spans, found...)
+	}
+	return zap.String("text", primitives.Redact(line.Text, primitives.MergeSpans(spans)))
+}
+
+// patternField returns the log field describing p
+// Pattern representations may contain the searched text, so only ids get logged unless full text is enabled
+func (f *Fscrub) patternField(p Pattern) zapcore.Field {
+	if f.textPolicy == TextFull {
+		return zap.String("pattern", p.String())
+	}
+	return zap.String("pattern", PatternID(p))
+}
@@ -0,0 +1,77 @@
+package fscrub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseTextPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    TextPolicy
+		wantErr bool
+	}{
+		{"default", "", TextRedacted, false},
+		{"redacted", "redacted", TextRedacted, false},
+		{"omit", "omit", TextOmitted, false},
+		{"full", "full", TextFull, false},
+		{"unknown", "some", TextRedacted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTextPolicy(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTextPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTextPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFscrub_TextPolicy(t *testing.T) {
+	line := Line{"test.txt", 0, "login foo from 10.0.0.1 with secret", false}
+	patterns := Patterns{
+		NewStringPattern("secret", "***"),
+		NewRegexPattern(`\d+\.\d+\.\d+\.\d+`, "ip"),
+	}
+	tests := []struct {
+		name        string
+		policy      TextPolicy
+		wantText    interface{}
+		wantPattern string
+	}{
+		{"redacted", TextRedacted, "login foo from *** with ***", "string-e5e9fa1b"},
+		{"omitted", TextOmitted, nil, "string-e5e9fa1b"},
+		{"full", TextFull, line.Text, "Source: secret - Target: ***"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			l := log.NewNop()
+			l.Logger = zap.New(core)
+			f := NewFscrub(l, true, patterns...).WithTextPolicy(tt.policy)
+			if _, err := f.HandleLine(context.Background(), line); err != nil {
+				t.Fatalf("Fscrub.HandleLine() error = %v", err)
+			}
+			found := logs.FilterMessage("found pattern").All()
+			if len(found) != 2 {
+				t.Fatalf("Fscrub.HandleLine() logged %d findings, want 2", len(found))
+			}
+			fields := found[0].ContextMap()
+			if fields["text"] != tt.wantText {
+				t.Errorf("Fscrub logged text = %v, want %v", fields["text"], tt.wantText)
+			}
+			if fields["pattern"] != tt.wantPattern {
+				t.Errorf("Fscrub logged pattern = %v, want %v", fields["pattern"], tt.wantPattern)
+			}
+		})
+	}
+}
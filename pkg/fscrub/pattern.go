@@ -1,23 +1,124 @@
 package fscrub
 
 import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Patterns .
 type Patterns []Pattern
 
 // Pattern .
+// ctx is done once the file in progress gets abandoned, patterns doing more than matching in memory should respect it
 type Pattern interface {
-	Find(s string, file string) (int, error)
-	Handle(s string, file string) (string, error)
+	Find(ctx context.Context, s string, file string) (int, error)
+	Handle(ctx context.Context, s string, file string) (string, error)
 	String() string
 }
 
+// Locator is implemented by patterns able to tell where in s they found something
+// Locate returns the byte ranges of all findings like regexp.FindAllStringIndex
+type Locator interface {
+	Locate(ctx context.Context, s string, file string) ([][]int, error)
+}
+
+// Identifier is implemented by patterns providing a stable rule id for reports
+type Identifier interface {
+	ID() string
+}
+
+// PatternID returns the rule id of p used in reports
+// Unlike String it never contains the searched text itself
+func PatternID(p Pattern) string {
+	if i, ok := p.(Identifier); ok {
+		return i.ID()
+	}
+	return "pattern"
+}
+
+// Locate returns the byte ranges where p found something in s
+// Patterns not implementing Locator are reported as matching the whole line
+func Locate(ctx context.Context, p Pattern, s string, file string) ([][]int, error) {
+	if l, ok := p.(Locator); ok {
+		return l.Locate(ctx, s, file)
+	}
+	return [][]int{{0, len(s)}}, nil
+}
+
+func hashID(prefix, s string) string {
+	return fmt.Sprintf("%s-%x", prefix, sha1.Sum([]byte(s)))[:len(prefix)+9]
+}
+
+// Fingerprint identifies the pattern set by the id, definition and severity of all its patterns
+// The order of patterns does not matter
+func Fingerprint(patterns Patterns) string {
+	defs := make([]string, len(patterns))
+	for i, p := range patterns {
+		defs[i] = fmt.Sprintf("%s\x00%s\x00%s", PatternID(p), p.String(), PatternSeverity(p))
+	}
+	sort.Strings(defs)
+	sum := sha256.Sum256([]byte(strings.Join(defs, "\n")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Severity rates how sensitive the findings of a pattern are
+type Severity int
+
+// Severities in ascending order
+const (
+	SeverityLow Severity = iota + 1
+	SeverityMedium
+	SeverityHigh
+)
+
+// Severities lists all valid severities in ascending order
+var Severities = []Severity{SeverityLow, SeverityMedium, SeverityHigh}
+
+// ParseSeverity from its config representation, defaulting to medium if empty
+func ParseSeverity(s string) (Severity, error) {
+	switch s {
+	case "low":
+		return SeverityLow, nil
+	case "medium", "":
+		return SeverityMedium, nil
+	case "high":
+		return SeverityHigh, nil
+	}
+	return SeverityMedium, fmt.Errorf("unsupported severity %q", s)
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "low"
+	case SeverityHigh:
+		return "high"
+	}
+	return "medium"
+}
+
+// Severer is implemented by patterns rating the severity of their findings
+type Severer interface {
+	Severity() Severity
+}
+
+// PatternSeverity returns the severity of p, defaulting to medium
+func PatternSeverity(p Pattern) Severity {
+	if s, ok := p.(Severer); ok {
+		return s.Severity()
+	}
+	return SeverityMedium
+}
+
 // PatternConfig defines the json containing patterns
 type PatternConfig struct {
 	Patterns Patterns `json:"patterns"`
@@ -52,14 +153,23 @@ func (c *PatternConfig) UnmarshalJSON(b []byte) error {
 			if err != nil {
 				return err
 			}
+			if _, err := ParseSeverity(p.Level); err != nil {
+				return err
+			}
 			c.Patterns[index] = &p
 		} else if m["type"] == "regex" {
-			var p RegexPattern
-			err := json.Unmarshal(*rawMessage, &p)
+			p := &RegexPattern{}
+			err := json.Unmarshal(*rawMessage, p)
 			if err != nil {
 				return err
 			}
-			c.Patterns[index] = &p
+			if _, err := ParseSeverity(p.Level); err != nil {
+				return err
+			}
+			if err := p.compile(); err != nil {
+				return err
+			}
+			c.Patterns[index] = p
 		} else {
 			return errors.New("unsupported type found")
 		}
@@ -71,8 +181,10 @@ func (c *PatternConfig) UnmarshalJSON(b []byte) error {
 
 // StringPattern defines a search and replace pattern
 type StringPattern struct {
+	Name   string `json:"name"`
 	Source string `json:"source"`
 	Target string `json:"target"`
+	Level  string `json:"severity"`
 }
 
 // NewStringPattern returns new pattern
@@ -84,44 +196,80 @@ func NewStringPattern(src, target string) *StringPattern {
 }
 
 // Find returns how often the source was found in string
-func (p *StringPattern) Find(s string, file string) (int, error) {
+func (p *StringPattern) Find(ctx context.Context, s string, file string) (int, error) {
 	return strings.Count(s, p.Source), nil
 }
 
 // Handle returns the string handled based pattern
-func (p *StringPattern) Handle(s string, file string) (string, error) {
+func (p *StringPattern) Handle(ctx context.Context, s string, file string) (string, error) {
 	return strings.Replace(s, p.Source, p.Target, -1), nil
 }
 
+// Locate returns the byte ranges of all occurrences of the source in string
+func (p *StringPattern) Locate(ctx context.Context, s string, file string) ([][]int, error) {
+	var spans [][]int
+	if p.Source == "" {
+		return spans, nil
+	}
+	offset := 0
+	for {
+		i := strings.Index(s[offset:], p.Source)
+		if i < 0 {
+			return spans, nil
+		}
+		start := offset + i
+		offset = start + len(p.Source)
+		spans = append(spans, []int{start, offset})
+	}
+}
+
 // String gives a representation of the pattern for logging
 func (p *StringPattern) String() string {
 	return fmt.Sprintf("Source: %s - Target: %s", p.Source, p.Target)
 }
 
+// ID returns the configured name or an id derived from the source
+func (p *StringPattern) ID() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return hashID("string", p.Source)
+}
+
+// Severity returns the configured severity
+func (p *StringPattern) Severity() Severity {
+	s, _ := ParseSeverity(p.Level)
+	return s
+}
+
 // RegexPattern defines a regex search with static replace
+// The regex is compiled on first use if Regex is not set, patterns are safe for concurrent use
 type RegexPattern struct {
+	Name        string `json:"name"`
 	RegexString string `json:"exp"`
 	Regex       *regexp.Regexp
 	Target      string `json:"target"`
+	Level       string `json:"severity"`
+
+	once       sync.Once
+	compileErr error
 }
 
 // NewRegexPattern compiles the regex and returns pattern
 func NewRegexPattern(exp, target string) *RegexPattern {
 	return &RegexPattern{
+		Name:        "",
 		RegexString: exp,
 		Regex:       nil,
 		Target:      target,
+		Level:       "",
 	}
 }
 
 // Find returns how often the regexp was found in string
-func (p *RegexPattern) Find(s string, file string) (int, error) {
-	if p.Regex == nil {
-		regex, err := regexp.Compile(p.RegexString)
-		if err != nil {
-			return -1, err
-		}
-		p.Regex = regex
+func (p *RegexPattern) Find(ctx context.Context, s string, file string) (int, error) {
+	if err := p.compile(); err != nil {
+		return -1, err
 	}
 
 	find := p.Regex.FindAllString(s, -1)
@@ -133,20 +281,48 @@ func (p *RegexPattern) Find(s string, file string) (int, error) {
 }
 
 // Handle returns the regexp handled with target
-func (p *RegexPattern) Handle(s string, file string) (string, error) {
-	if p.Regex == nil {
-		regex, err := regexp.Compile(p.RegexString)
-		if err != nil {
-			return s, err
-		}
-		p.Regex = regex
+func (p *RegexPattern) Handle(ctx context.Context, s string, file string) (string, error) {
+	if err := p.compile(); err != nil {
+		return s, err
 	}
 
 	s = p.Regex.ReplaceAllString(s, p.Target)
 	return s, nil
 }
 
+// Locate returns the byte ranges of all matches of the regexp in string
+func (p *RegexPattern) Locate(ctx context.Context, s string, file string) ([][]int, error) {
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return p.Regex.FindAllStringIndex(s, -1), nil
+}
+
+// compile the regex once unless it is already set
+func (p *RegexPattern) compile() error {
+	p.once.Do(func() {
+		if p.Regex == nil {
+			p.Regex, p.compileErr = regexp.Compile(p.RegexString)
+		}
+	})
+	return p.compileErr
+}
+
 // String gives a representation of the pattern for logging
 func (p *RegexPattern) String() string {
 	return fmt.Sprintf("Regex: %s - Target: %s", p.RegexString, p.Target)
 }
+
+// ID returns the configured name or an id derived from the expression
+func (p *RegexPattern) ID() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return hashID("regex", p.RegexString)
+}
+
+// Severity returns the configured severity
+func (p *RegexPattern) Severity() Severity {
+	s, _ := ParseSeverity(p.Level)
+	return s
+}
@@ -1,7 +1,9 @@
 package fscrub
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -34,6 +36,26 @@ func TestPatternConfig_UnmarshalJSON(t *testing.T) {
 			}`),
 			true,
 		},
+		{
+			"unknownSeverity",
+			&PatternConfig{},
+			[]byte(`{
+				"patterns": [
+					{"type": "string", "source": "foo", "target": "bar", "severity": "critical"}
+				]
+			}`),
+			true,
+		},
+		{
+			"invalidRegex",
+			&PatternConfig{},
+			[]byte(`{
+				"patterns": [
+					{"type": "regex", "exp": "t\\s(*\\w+", "target": "bar"}
+				]
+			}`),
+			true,
+		},
 		{
 			"unknownType",
 			&PatternConfig{},
@@ -93,7 +115,7 @@ func TestPattern_Find(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.p.Find(tt.s, "")
+			got, err := tt.p.Find(context.Background(), tt.s, "")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Pattern.Find() = error %v, want %v", got, tt.wantErr)
 			}
@@ -143,7 +165,7 @@ func TestPattern_Handle(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.p.Handle(tt.s, "")
+			got, err := tt.p.Handle(context.Background(), tt.s, "")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Pattern.Handle() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -155,6 +177,126 @@ func TestPattern_Handle(t *testing.T) {
 	}
 }
 
+func TestPattern_Locate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       Pattern
+		s       string
+		want    [][]int
+		wantErr bool
+	}{
+		{
+			"string",
+			NewStringPattern("foo", "bar"),
+			"foo bar foo",
+			[][]int{{0, 3}, {8, 11}},
+			false,
+		},
+		{
+			"stringNone",
+			NewStringPattern("foo", "bar"),
+			"abc",
+			nil,
+			false,
+		},
+		{
+			"regex",
+			NewRegexPattern("t\\s\\*\\w+", "f *foo"),
+			"func(t *testing.T)",
+			[][]int{{5, 15}},
+			false,
+		},
+		{
+			"regexErr",
+			NewRegexPattern("t\\s(*\\w+", "bar"),
+			"abc",
+			nil,
+			true,
+		},
+		{
+			"noLocator",
+			&mockErrHandlePattern{},
+			"abc",
+			[][]int{{0, 3}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Locate(context.Background(), tt.p, tt.s, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Locate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Locate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternID(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Pattern
+		want string
+	}{
+		{"named", &StringPattern{Name: "password", Source: "foo"}, "password"},
+		{"string", NewStringPattern("foo", "bar"), "string-0beec7b5"},
+		{"regex", NewRegexPattern("foo", "bar"), "regex-0beec7b5"},
+		{"unknown", &mockErrHandlePattern{}, "pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PatternID(tt.p); got != tt.want {
+				t.Errorf("PatternID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	foo, bar := NewStringPattern("foo", "x"), NewRegexPattern("bar", "x")
+	base := Fingerprint(Patterns{foo, bar})
+	if len(base) != 16 {
+		t.Errorf("Fingerprint() = %q, want 16 characters", base)
+	}
+	if got := Fingerprint(Patterns{bar, foo}); got != base {
+		t.Errorf("Fingerprint() depends on order, got %q, want %q", got, base)
+	}
+	changed := []Patterns{
+		{foo},
+		{foo, NewRegexPattern("baz", "x")},
+		{foo, bar, NewStringPattern("baz", "x")},
+		{foo, &RegexPattern{Name: PatternID(bar), RegexString: "bar", Regex: bar.Regex, Target: "x", Level: "high"}},
+	}
+	for _, patterns := range changed {
+		if got := Fingerprint(patterns); got == base {
+			t.Errorf("Fingerprint(%v) = %q, want a different fingerprint", patterns, got)
+		}
+	}
+}
+
+func TestPatternSeverity(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Pattern
+		want Severity
+	}{
+		{"default", NewStringPattern("foo", "bar"), SeverityMedium},
+		{"string", &StringPattern{Source: "foo", Level: "high"}, SeverityHigh},
+		{"regex", &RegexPattern{RegexString: "foo", Level: "low"}, SeverityLow},
+		{"unknown", &mockErrHandlePattern{}, SeverityMedium},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PatternSeverity(tt.p); got != tt.want {
+				t.Errorf("PatternSeverity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNewRegexPattern(t *testing.T) {
 	type args struct {
 		exp    string
@@ -170,11 +312,11 @@ func TestNewRegexPattern(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			pattern := NewRegexPattern(tt.args.exp, tt.args.target)
-			if _, err := pattern.Find("", ""); (err != nil) != tt.want {
+			if _, err := pattern.Find(context.Background(), "", ""); (err != nil) != tt.want {
 				t.Errorf("NewRegexPattern() = %v, want %v", err, tt.want)
 			}
 			pattern = NewRegexPattern(tt.args.exp, tt.args.target)
-			if _, err := pattern.Handle("", ""); (err != nil) != tt.want {
+			if _, err := pattern.Handle(context.Background(), "", ""); (err != nil) != tt.want {
 				t.Errorf("NewRegexPattern() = %v, want %v", err, tt.want)
 			}
 		})
@@ -183,11 +325,11 @@ func TestNewRegexPattern(t *testing.T) {
 
 type mockErrFindPattern struct{}
 
-func (p *mockErrFindPattern) Find(s, f string) (int, error) {
+func (p *mockErrFindPattern) Find(ctx context.Context, s, f string) (int, error) {
 	return 0, errors.New("test error")
 }
 
-func (p *mockErrFindPattern) Handle(s, f string) (string, error) {
+func (p *mockErrFindPattern) Handle(ctx context.Context, s, f string) (string, error) {
 	return "", errors.New("test error")
 }
 
@@ -197,11 +339,11 @@ func (p *mockErrFindPattern) String() string {
 
 type mockErrHandlePattern struct{}
 
-func (p *mockErrHandlePattern) Find(s, f string) (int, error) {
+func (p *mockErrHandlePattern) Find(ctx context.Context, s, f string) (int, error) {
 	return 1, nil
 }
 
-func (p *mockErrHandlePattern) Handle(s, f string) (string, error) {
+func (p *mockErrHandlePattern) Handle(ctx context.Context, s, f string) (string, error) {
 	return "", errors.New("test error")
 }
 
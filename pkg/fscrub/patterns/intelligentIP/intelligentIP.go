@@ -1,6 +1,7 @@
 package intelligentIP
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"sync"
@@ -32,7 +33,7 @@ func New() *Pattern {
 }
 
 // Find returns how often the regexp was found in string
-func (p *Pattern) Find(s string, file string) (int, error) {
+func (p *Pattern) Find(ctx context.Context, s string, file string) (int, error) {
 	p.checkFile(file)
 	find := p.Regex.FindAllString(s, -1)
 	if find == nil {
@@ -43,7 +44,7 @@ func (p *Pattern) Find(s string, file string) (int, error) {
 }
 
 // Handle returns the regexp handled with target
-func (p *Pattern) Handle(s string, file string) (string, error) {
+func (p *Pattern) Handle(ctx context.Context, s string, file string) (string, error) {
 	p.checkFile(file)
 	s = p.Regex.ReplaceAllStringFunc(s, func(ip string) string {
 		return p.checkIP(file, ip)
@@ -51,11 +52,21 @@ func (p *Pattern) Handle(s string, file string) (string, error) {
 	return s, nil
 }
 
+// Locate returns the byte ranges of all ips found in string
+func (p *Pattern) Locate(ctx context.Context, s string, file string) ([][]int, error) {
+	return p.Regex.FindAllStringIndex(s, -1), nil
+}
+
 // String gives a representation of the pattern for logging
 func (p *Pattern) String() string {
 	return fmt.Sprintf("intelligentIP")
 }
 
+// ID returns the rule id of the pattern used in reports
+func (p *Pattern) ID() string {
+	return "intelligentIP"
+}
+
 func (p *Pattern) checkFile(file string) {
 	p.m.Lock()
 	defer p.m.Unlock()
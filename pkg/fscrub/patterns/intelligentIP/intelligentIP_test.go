@@ -1,6 +1,8 @@
 package intelligentIP
 
 import (
+	"context"
+	"reflect"
 	"testing"
 )
 
@@ -51,7 +53,7 @@ func TestPattern_Find(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := p.Find(tt.s, tt.file)
+			got, err := p.Find(context.Background(), tt.s, tt.file)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Pattern.Find() = error %v, want %v", got, tt.wantErr)
 			}
@@ -109,7 +111,7 @@ func TestPattern_Handle(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := p.Handle(tt.s, tt.file)
+			got, err := p.Handle(context.Background(), tt.s, tt.file)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Pattern.Find() = error %v, want %v", got, tt.wantErr)
 			}
@@ -120,6 +122,21 @@ func TestPattern_Handle(t *testing.T) {
 	}
 }
 
+func TestPattern_Locate(t *testing.T) {
+	p := New()
+	got, err := p.Locate(context.Background(), "from 127.0.0.1 to 10.0.0.2", "file1")
+	if err != nil {
+		t.Errorf("Pattern.Locate() error = %v", err)
+	}
+	want := [][]int{{5, 14}, {18, 26}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Pattern.Locate() = %v, want %v", got, want)
+	}
+	if p.ID() != "intelligentIP" {
+		t.Error("invalid ID()")
+	}
+}
+
 func TestString(t *testing.T) {
 	p := New()
 	if p.String() != "intelligentIP" {
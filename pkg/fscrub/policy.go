@@ -0,0 +1,115 @@
+package fscrub
+
+import (
+	"fmt"
+
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
+)
+
+// scope is what gets applied to a single file
+type scope struct {
+	patterns    Patterns
+	fingerprint string
+	// report only detects findings, leaving the content unchanged
+	report bool
+	header bool
+}
+
+// selection is a subset of the patterns chosen by a policy
+type selection struct {
+	patterns    Patterns
+	fingerprint string
+}
+
+// WithPolicies selects the patterns and action per file using the policy files found by r
+// Policy files handled by Handle are not scrubbed but reloaded, so changes apply to the next files
+func (f *Fscrub) WithPolicies(r *fspolicy.Resolver) *Fscrub {
+	f.policies = r
+	return f
+}
+
+// defaultScope applies all patterns
+func (f *Fscrub) defaultScope() *scope {
+	return &scope{
+		patterns:    f.patterns,
+		fingerprint: f.fingerprint,
+		report:      f.dry,
+		header:      true,
+	}
+}
+
+// scopeOf the file at path according to its policy, nil if the file is skipped
+func (f *Fscrub) scopeOf(path string) (*scope, error) {
+	if f.policies == nil {
+		return f.defaultScope(), nil
+	}
+	policy, err := f.policies.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Action == fspolicy.ActionSkip {
+		return nil, nil
+	}
+	s, err := f.selection(policy)
+	if err != nil {
+		return nil, err
+	}
+	return &scope{
+		patterns:    s.patterns,
+		fingerprint: s.fingerprint,
+		report:      f.dry || policy.Action == fspolicy.ActionReport,
+		header:      policy.Header,
+	}, nil
+}
+
+// selection of the patterns chosen by policy
+// Unknown pattern ids are an error, as a typo would silently stop patterns from being applied
+func (f *Fscrub) selection(policy fspolicy.Policy) (selection, error) {
+	key := policy.Key()
+	f.m.Lock()
+	defer f.m.Unlock()
+	if s, ok := f.selections[key]; ok {
+		return s, nil
+	}
+
+	known := make(map[string]bool, len(f.patterns))
+	for _, p := range f.patterns {
+		known[PatternID(p)] = true
+	}
+	for _, id := range append(append([]string{}, policy.Patterns...), policy.Disabled...) {
+		if !known[id] {
+			return selection{}, fmt.Errorf("unknown pattern %q in policy", id)
+		}
+	}
+	s := selection{patterns: Patterns{}}
+	for _, p := range f.patterns {
+		if policy.Selects(PatternID(p)) {
+			s.patterns = append(s.patterns, p)
+		}
+	}
+	s.fingerprint = Fingerprint(s.patterns)
+	if f.selections == nil {
+		f.selections = make(map[string]selection)
+	}
+	f.selections[key] = s
+	return s, nil
+}
+
+// FingerprintOf the pattern set and action applied to the file at path
+// It changes whenever the file would be treated differently, e.g. after its policy changed
+func (f *Fscrub) FingerprintOf(path string) string {
+	sc, err := f.scopeOf(path)
+	if err != nil {
+		return "invalid-policy"
+	}
+	if sc == nil {
+		return string(fspolicy.ActionSkip)
+	}
+	if sc.report {
+		return sc.fingerprint + "-" + string(fspolicy.ActionReport)
+	}
+	if !sc.header {
+		return sc.fingerprint + "-noheader"
+	}
+	return sc.fingerprint
+}
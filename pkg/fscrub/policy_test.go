@@ -0,0 +1,100 @@
+package fscrub
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/playnet-public/fscrub/pkg/fscrub/patterns/intelligentIP"
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestFscrub_Policies(t *testing.T) {
+	fs := vfs.NewMem()
+	content := "login secret from 10.0.0.1\n"
+	files := map[string]string{
+		"data/logs/.fscrub.yml":   "patterns: [intelligentIP, secrets]\n",
+		"data/public/.fscrub.yml": "patterns: [secrets]\n",
+		"data/staff/.fscrub.yml":  "action: skip\n",
+		"data/report/.fscrub.yml": "action: report\n",
+		"data/typo/.fscrub.yml":   "patterns: [secret]\n",
+		"data/logs/a.log":         content,
+		"data/public/a.log":       content,
+		"data/staff/a.log":        content,
+		"data/report/a.log":       content,
+		"data/typo/a.log":         content,
+		"data/other.log":          content,
+	}
+	for name, c := range files {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(name, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secrets := &StringPattern{Name: "secrets", Source: "secret", Target: "***"}
+	ip := intelligentIP.New()
+	other := NewStringPattern("login", "user")
+	f := NewFscrub(log.NewNop(), false, ip, secrets, other).
+		WithFilesystem(fs).
+		WithPolicies(fspolicy.NewResolver(log.NewNop(), fs, "data"))
+	header := func(patterns ...Pattern) string {
+		return strings.Join(primitives.BuildHeaderWithPatternSet(Fingerprint(patterns)), "\n") + "\n"
+	}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"data/logs/a.log", header(ip, secrets) + "login *** from client0.ip.fscrub.org\n", false},
+		{"data/public/a.log", header(secrets) + "login *** from 10.0.0.1\n", false},
+		{"data/staff/a.log", content, false},
+		{"data/report/a.log", content, false},
+		{"data/typo/a.log", content, true},
+		{"data/other.log", header(ip, secrets, other) + "user *** from client0.ip.fscrub.org\n", false},
+		{"data/staff/.fscrub.yml", "action: skip\n", false},
+	}
+	for _, tt := range tests {
+		info, err := fs.Stat(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Handle(context.Background(), tt.path, info); (err != nil) != tt.wantErr {
+			t.Errorf("Fscrub.Handle(%s) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+		data, err := vfs.ReadFile(fs, tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Fscrub.Handle(%s) = %q, want %q", tt.path, data, tt.want)
+		}
+	}
+	if s := f.Summary(); s.Files != 5 || s.Changed != 4 || s.Errors != 1 {
+		t.Errorf("Fscrub.Summary() = %v, want 5 files, 4 changed, 1 error", s)
+	}
+
+	// handling a policy file reloads it
+	if f.FingerprintOf("data/staff/a.log") != string(fspolicy.ActionSkip) {
+		t.Errorf("Fscrub.FingerprintOf() = %q, want skip", f.FingerprintOf("data/staff/a.log"))
+	}
+	if err := fs.WriteFile("data/staff/.fscrub.yml", []byte("patterns: [secrets]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("data/staff/.fscrub.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Handle(context.Background(), "data/staff/.fscrub.yml", info); err != nil {
+		t.Fatalf("Fscrub.Handle() policy error = %v", err)
+	}
+	if got, want := f.FingerprintOf("data/staff/a.log"), Fingerprint(Patterns{secrets}); got != want {
+		t.Errorf("Fscrub.FingerprintOf() = %q after reload, want %q", got, want)
+	}
+}
@@ -0,0 +1,264 @@
+package fscrub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/playnet-public/libs/log"
+
+	"github.com/playnet-public/fscrub/pkg/fsreport"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"go.uber.org/zap"
+)
+
+// Options configure a single Scrub run
+type Options struct {
+	// Name identifies the content in logs and reports, patterns like intelligentIP keep their state per name
+	Name string
+	// Header removes existing fscrub headers and prepends a new one if the content changed
+	// To do so the whole content gets buffered before anything is written
+	Header bool
+	// Passthrough writes the content unchanged while findings are still logged and reported
+	Passthrough bool
+}
+
+// Result summarizes a single Scrub run
+type Result struct {
+	// Lines read from the content
+	Lines int
+	// Changed is true if any pattern found something
+	Changed bool
+	// Skipped is true if the content contained the ignore header and was written unchanged
+	Skipped bool
+	// Refreshed is true if the header of unchanged content was replaced because it recorded another pattern set
+	Refreshed bool
+	// Findings counts the findings by pattern id
+	Findings map[string]int
+
+	// findings not reported yet
+	findings []fsreport.Finding
+}
+
+// Scrub r into w using patterns
+// It is the shorthand for embedding fscrub without logging, see Fscrub.Scrub for details
+func Scrub(ctx context.Context, r io.Reader, w io.Writer, patterns Patterns, opts Options) (Result, error) {
+	f := NewFscrub(&log.Logger{Logger: zap.NewNop()}, false, patterns...)
+	return f.Scrub(ctx, r, w, opts)
+}
+
+// Scrub reads r line by line, applies all patterns and writes the result to w
+// Line endings are preserved. Unless a header is requested, output is flushed whenever
+// no further input is buffered, so Scrub can be used on never ending streams
+// Content containing the ignore header is written unchanged from that line on.
+// Scrub stops with the error of ctx before the next line once it is done
+func (f *Fscrub) Scrub(ctx context.Context, r io.Reader, w io.Writer, opts Options) (Result, error) {
+	return f.scrub(ctx, r, w, opts, f.defaultScope(), true)
+}
+
+// scrub r into w using the patterns of sc
+// If report is set, findings are reported once their lines are flushed to w, otherwise they are left in the result for the caller
+func (f *Fscrub) scrub(ctx context.Context, r io.Reader, w io.Writer, opts Options, sc *scope, report bool) (res Result, err error) {
+	res = Result{Findings: make(map[string]int)}
+	if report {
+		defer func() {
+			action := f.action(sc, opts.Passthrough)
+			if err != nil {
+				action = fsreport.ActionFailed
+			}
+			if rerr := f.emit(res.findings, action); rerr != nil && err == nil {
+				err = rerr
+			}
+			res.findings = nil
+		}()
+	}
+
+	in := bufio.NewReader(r)
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	// with headers, output is collected until it is known whether it changed
+	var original, scrubbed bytes.Buffer
+	// the pattern set recorded in an existing header
+	var headerFound bool
+	var headerPatterns string
+	var dst io.Writer = out
+	if opts.Header {
+		dst = &scrubbed
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		text, err := in.ReadString('\n')
+		if len(text) > 0 {
+			content := strings.TrimRight(text, "\r\n")
+			ending := text[len(content):]
+			line := Line{
+				Path:    opts.Name,
+				No:      res.Lines,
+				Text:    content,
+				Changed: false,
+			}
+			res.Lines = res.Lines + 1
+			if opts.Header {
+				original.WriteString(text)
+			}
+
+			if content == primitives.BuildIgnoreHeader() {
+				f.log.Info(
+					"skipping file",
+					zap.String("action", "fscrub"),
+					zap.String("path", opts.Name),
+					zap.String("reason", "skip-header"),
+				)
+				res.Skipped = true
+				res.Changed = false
+				if opts.Header {
+					if _, err := out.Write(original.Bytes()); err != nil {
+						return res, err
+					}
+				} else if _, err := out.WriteString(text); err != nil {
+					return res, err
+				}
+				_, err := io.Copy(out, in)
+				if err != nil {
+					return res, err
+				}
+				return res, out.Flush()
+			}
+
+			if opts.Header && isHeaderLine(content) {
+				if line.No == 0 && content == primitives.BuildHeader()[0] {
+					headerFound = true
+				}
+				if headerFound && strings.HasPrefix(content, primitives.PatternSetPrefix) {
+					headerPatterns = strings.TrimPrefix(content, primitives.PatternSetPrefix)
+				}
+				continue
+			}
+
+			new, findings, err := f.handleLine(ctx, line, sc, &res)
+			res.findings = append(res.findings, findings...)
+			if err != nil {
+				f.log.Error("failed handling line",
+					zap.String("file", opts.Name),
+					zap.Int("line", line.No),
+					f.textField(line),
+					zap.Error(err))
+				return res, err
+			}
+			if new.Changed {
+				res.Changed = true
+				if !opts.Passthrough {
+					line = new
+				}
+			}
+			if _, err := io.WriteString(dst, line.Text+ending); err != nil {
+				return res, err
+			}
+			if !opts.Header && in.Buffered() == 0 {
+				if err := out.Flush(); err != nil {
+					return res, err
+				}
+				// streams may never end, so findings are reported as soon as they are written
+				if report {
+					if err := f.emit(res.findings, f.action(sc, opts.Passthrough)); err != nil {
+						return res, err
+					}
+					res.findings = nil
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+
+	if opts.Header {
+		res.Refreshed = !res.Changed && headerFound && headerPatterns != sc.fingerprint
+		if (res.Changed || res.Refreshed) && !opts.Passthrough {
+			_, err := out.WriteString(strings.Join(primitives.BuildHeaderWithPatternSet(sc.fingerprint), "\n") + "\n")
+			if err != nil {
+				return res, err
+			}
+			_, err = out.Write(scrubbed.Bytes())
+			if err != nil {
+				return res, err
+			}
+		} else if _, err := out.Write(original.Bytes()); err != nil {
+			return res, err
+		}
+	}
+	return res, out.Flush()
+}
+
+// Filter scrubs r line by line and writes the result to w
+// Unlike Handle no header is added, so Filter can be used on never ending streams
+// name identifies the stream for patterns like intelligentIP and in logs
+// In dry runs the input is written unchanged while findings are still logged and reported
+func (f *Fscrub) Filter(ctx context.Context, name string, r io.Reader, w io.Writer) (err error) {
+	changed := false
+	defer func() {
+		f.stats.file(changed, err)
+	}()
+
+	f.log.Info("stream scan started", zap.String("file", name))
+	res, err := f.Scrub(ctx, r, w, Options{Name: name, Passthrough: f.dry})
+	changed = res.Changed
+	if err != nil {
+		f.log.Error("stream scan failed", zap.String("file", name), zap.Error(err))
+		return err
+	}
+	f.log.Info("stream scan finished", zap.String("file", name))
+	return nil
+}
+
+func isHeaderLine(s string) bool {
+	if strings.HasPrefix(s, primitives.PatternSetPrefix) {
+		return true
+	}
+	for _, hl := range primitives.BuildHeader() {
+		if s == hl {
+			return true
+		}
+	}
+	return false
+}
+
+// headerPatternSet returns the pattern set recorded in the fscrub header at the start of head
+// found is false if head does not start with a header
+func headerPatternSet(head []byte) (fingerprint string, found bool) {
+	header := primitives.BuildHeader()
+	for i, line := range strings.Split(string(head), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if i == 0 && line != header[0] {
+			return "", false
+		}
+		if strings.HasPrefix(line, primitives.PatternSetPrefix) {
+			return strings.TrimPrefix(line, primitives.PatternSetPrefix), true
+		}
+		if !isHeaderLine(line) {
+			break
+		}
+	}
+	return "", true
+}
+
+// splitLines returns the lines of s without line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
@@ -0,0 +1,223 @@
+package fscrub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/playnet-public/fscrub/pkg/fscrub/patterns/intelligentIP"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestFscrub_Filter(t *testing.T) {
+	log := log.NewNop()
+	tests := []struct {
+		name    string
+		f       *Fscrub
+		r       io.Reader
+		want    string
+		wantErr bool
+	}{
+		{
+			"basic",
+			NewFscrub(log, false, NewStringPattern("foo", "bar")),
+			strings.NewReader("foo\nabc\r\nfoo"),
+			"bar\nabc\r\nbar",
+			false,
+		},
+		{
+			"intelligentIP",
+			NewFscrub(log, false, intelligentIP.New()),
+			strings.NewReader("from 10.0.0.1\nfrom 10.0.0.2\nfrom 10.0.0.1\n"),
+			"from client0.ip.fscrub.org\nfrom client1.ip.fscrub.org\nfrom client0.ip.fscrub.org\n",
+			false,
+		},
+		{
+			"dryRun",
+			NewFscrub(log, true, NewStringPattern("foo", "bar")),
+			strings.NewReader("foo\n"),
+			"foo\n",
+			false,
+		},
+		{
+			"skipHeader",
+			NewFscrub(log, false, NewStringPattern("foo", "bar")),
+			strings.NewReader("//-ignore: github.com/playnet-public/fscrub\nfoo\n"),
+			"//-ignore: github.com/playnet-public/fscrub\nfoo\n",
+			false,
+		},
+		{
+			"handleErr",
+			NewFscrub(log, false, &mockErrFindPattern{}),
+			strings.NewReader("foo\n"),
+			"",
+			true,
+		},
+		{
+			"readErr",
+			NewFscrub(log, false, NewStringPattern("foo", "bar")),
+			io.MultiReader(strings.NewReader("foo\n"), &errReader{}),
+			"bar\n",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.f.Filter(context.Background(), "stdin", tt.r, &buf); (err != nil) != tt.wantErr {
+				t.Errorf("Fscrub.Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Fscrub.Filter() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestScrub(t *testing.T) {
+	patterns := Patterns{
+		&StringPattern{Name: "foo", Source: "foo", Target: "bar"},
+	}
+	header := strings.Join(primitives.BuildHeaderWithPatternSet(Fingerprint(patterns)), "\n") + "\n"
+	legacyHeader := strings.Join(primitives.BuildHeader(), "\n") + "\n"
+	outdatedHeader := strings.Join(primitives.BuildHeaderWithPatternSet("0123456789abcdef"), "\n") + "\n"
+	tests := []struct {
+		name    string
+		content string
+		opts    Options
+		want    string
+		wantRes Result
+	}{
+		{
+			"stream",
+			"foo\nabc\nfoo foo",
+			Options{Name: "stream"},
+			"bar\nabc\nbar bar",
+			Result{Lines: 3, Changed: true, Findings: map[string]int{"foo": 3}},
+		},
+		{
+			"header",
+			"foo\r\nabc\r\n",
+			Options{Name: "file", Header: true},
+			header + "bar\r\nabc\r\n",
+			Result{Lines: 2, Changed: true, Findings: map[string]int{"foo": 1}},
+		},
+		{
+			"headerReplaced",
+			header + "foo\n",
+			Options{Name: "file", Header: true},
+			header + "bar\n",
+			Result{Lines: 7, Changed: true, Findings: map[string]int{"foo": 1}},
+		},
+		{
+			"headerUnchanged",
+			header + "abc\n",
+			Options{Name: "file", Header: true},
+			header + "abc\n",
+			Result{Lines: 7, Changed: false, Findings: map[string]int{}},
+		},
+		{
+			"headerOutdated",
+			outdatedHeader + "abc\n",
+			Options{Name: "file", Header: true},
+			header + "abc\n",
+			Result{Lines: 7, Refreshed: true, Findings: map[string]int{}},
+		},
+		{
+			"headerLegacy",
+			legacyHeader + "abc\n",
+			Options{Name: "file", Header: true},
+			header + "abc\n",
+			Result{Lines: 6, Refreshed: true, Findings: map[string]int{}},
+		},
+		{
+			"headerLineInContent",
+			"abc\n////\n",
+			Options{Name: "file", Header: true},
+			"abc\n////\n",
+			Result{Lines: 2, Findings: map[string]int{}},
+		},
+		{
+			"passthrough",
+			"foo\n",
+			Options{Name: "file", Header: true, Passthrough: true},
+			"foo\n",
+			Result{Lines: 1, Changed: true, Findings: map[string]int{"foo": 1}},
+		},
+		{
+			"skipped",
+			"foo\n//-ignore: github.com/playnet-public/fscrub\nfoo\n",
+			Options{Name: "file", Header: true},
+			"foo\n//-ignore: github.com/playnet-public/fscrub\nfoo\n",
+			Result{Lines: 2, Skipped: true, Findings: map[string]int{"foo": 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			res, err := Scrub(context.Background(), strings.NewReader(tt.content), &buf, patterns, tt.opts)
+			if err != nil {
+				t.Fatalf("Scrub() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Scrub() = %q, want %q", buf.String(), tt.want)
+			}
+			if !reflect.DeepEqual(res, tt.wantRes) {
+				t.Errorf("Scrub() result = %+v, want %+v", res, tt.wantRes)
+			}
+		})
+	}
+}
+
+func TestScrub_Concurrent(t *testing.T) {
+	// patterns are shared by all workers, the regex gets compiled by whichever comes first
+	patterns := Patterns{NewRegexPattern(`secret\d+`, "***"), NewStringPattern("token", "***")}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			_, err := Scrub(context.Background(), strings.NewReader("token secret1\nsecret22\n"), &buf, patterns, Options{})
+			if err != nil || buf.String() != "*** ***\n***\n" {
+				t.Errorf("Scrub() = %q, %v", buf.String(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHeaderPatternSet(t *testing.T) {
+	tests := []struct {
+		name            string
+		head            string
+		wantFingerprint string
+		wantFound       bool
+	}{
+		{"none", "abc\n", "", false},
+		{"empty", "", "", false},
+		{"legacy", strings.Join(primitives.BuildHeader(), "\n") + "\nabc\n", "", true},
+		{"patternSet", strings.Join(primitives.BuildHeaderWithPatternSet("abc"), "\r\n") + "\r\nfoo\n", "abc", true},
+		{"truncated", strings.Join(primitives.BuildHeaderWithPatternSet("abc"), "\n")[:40], "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fingerprint, found := headerPatternSet([]byte(tt.head))
+			if fingerprint != tt.wantFingerprint || found != tt.wantFound {
+				t.Errorf("headerPatternSet() = %q, %v, want %q, %v", fingerprint, found, tt.wantFingerprint, tt.wantFound)
+			}
+		})
+	}
+}
+
+type errReader struct{}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read error")
+}
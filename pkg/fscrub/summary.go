@@ -0,0 +1,77 @@
+package fscrub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Summary of all files handled by Fscrub
+type Summary struct {
+	Files    int
+	Changed  int
+	Errors   int
+	Findings map[Severity]int
+}
+
+// FindingsAbove returns the number of findings rated threshold or higher
+func (s Summary) FindingsAbove(threshold Severity) int {
+	count := 0
+	for sev, c := range s.Findings {
+		if sev >= threshold {
+			count = count + c
+		}
+	}
+	return count
+}
+
+func (s Summary) String() string {
+	var findings []string
+	total := 0
+	for _, sev := range Severities {
+		findings = append(findings, fmt.Sprintf("%s: %d", sev, s.Findings[sev]))
+		total = total + s.Findings[sev]
+	}
+	return fmt.Sprintf(
+		"%d files scanned, %d files with findings, %d findings (%s), %d errors",
+		s.Files, s.Changed, total, strings.Join(findings, ", "), s.Errors,
+	)
+}
+
+// stats collects the Summary while files are handled concurrently
+type stats struct {
+	m       sync.Mutex
+	summary Summary
+}
+
+func (s *stats) file(changed bool, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.summary.Files++
+	if changed {
+		s.summary.Changed++
+	}
+	if err != nil {
+		s.summary.Errors++
+	}
+}
+
+func (s *stats) finding(sev Severity, count int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.summary.Findings == nil {
+		s.summary.Findings = make(map[Severity]int)
+	}
+	s.summary.Findings[sev] = s.summary.Findings[sev] + count
+}
+
+func (s *stats) get() Summary {
+	s.m.Lock()
+	defer s.m.Unlock()
+	summary := s.summary
+	summary.Findings = make(map[Severity]int)
+	for sev, c := range s.summary.Findings {
+		summary.Findings[sev] = c
+	}
+	return summary
+}
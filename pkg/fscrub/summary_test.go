@@ -0,0 +1,55 @@
+package fscrub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/playnet-public/libs/log"
+)
+
+func TestFscrub_Summary(t *testing.T) {
+	f := NewFscrub(log.NewNop(), true,
+		&StringPattern{Source: "secret", Target: "***", Level: "high"},
+		&StringPattern{Source: "foo", Target: "bar", Level: "low"},
+	).WithDiffer(DiscardDiff)
+
+	files := map[string]string{
+		"clean.txt":    "abc\ndef\n",
+		"findings.txt": "secret\nfoo foo\n",
+	}
+	for name, content := range files {
+		file, path, err := createTempFile(name, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if err := f.Handle(context.Background(), path, newMockFileInfo(false)); err != nil {
+			t.Errorf("Fscrub.Handle() error = %v", err)
+		}
+	}
+	if err := f.Handle(context.Background(), "notexist.txt", newMockFileInfo(false)); err == nil {
+		t.Errorf("Fscrub.Handle() expected error for missing file")
+	}
+
+	s := f.Summary()
+	if s.Files != 3 || s.Changed != 1 || s.Errors != 1 {
+		t.Errorf("Fscrub.Summary() = %v, want 3 files, 1 changed, 1 error", s)
+	}
+	tests := []struct {
+		threshold Severity
+		want      int
+	}{
+		{SeverityLow, 3},
+		{SeverityMedium, 1},
+		{SeverityHigh, 1},
+	}
+	for _, tt := range tests {
+		if got := s.FindingsAbove(tt.threshold); got != tt.want {
+			t.Errorf("Summary.FindingsAbove(%v) = %v, want %v", tt.threshold, got, tt.want)
+		}
+	}
+	want := "3 files scanned, 1 files with findings, 3 findings (low: 2, medium: 0, high: 1), 1 errors"
+	if s.String() != want {
+		t.Errorf("Summary.String() = %q, want %q", s.String(), want)
+	}
+}
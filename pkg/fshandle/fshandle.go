@@ -1,8 +1,11 @@
 package fshandle
 
 import (
+	"context"
 	"github.com/playnet-public/libs/log"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/playnet-public/fscrub/pkg/model"
 	"go.uber.org/zap"
@@ -16,6 +19,13 @@ type FsHandler struct {
 	Handlers []model.Handler
 
 	log *log.Logger
+
+	restartPolicy RestartPolicy
+	restarts      int
+	backoff       time.Duration
+
+	m     sync.Mutex
+	units []*unit
 }
 
 // NewFsHandler creates a fscrub instance with defaults if necessary
@@ -32,22 +42,49 @@ func NewFsHandler(
 		Dirs:     dirs,
 		Handlers: handlers,
 		log:      log,
+		restarts: DefaultRestarts,
+		backoff:  DefaultRestartBackoff,
 	}
 	return f
 }
 
-// Run watcher and crawler for all dirs
-func (f *FsHandler) Run() error {
+// Run every handler for every dir until all of them are done or ctx is done
+// Handlers failing for a dir do not affect the others, they are restarted according to the restart policy.
+// Once Run returns, all handlers are stopped and the files in progress are finished or left unchanged.
+// The error lists all handlers which failed for good
+func (f *FsHandler) Run(ctx context.Context) error {
 	f.log.Info("running fscrub")
-	erc := make(chan error)
+	f.m.Lock()
+	f.units = nil
 	for _, d := range f.Dirs {
 		for _, h := range f.Handlers {
-			f.log.Info("starting handler", zap.String("type", reflect.TypeOf(h).String()), zap.String("dir", d.String()))
-			go h.Run(d, erc)
-			defer h.Stop()
+			f.units = append(f.units, &unit{
+				handler: h,
+				status: Status{
+					Dir:     d,
+					Handler: reflect.TypeOf(h).String(),
+				},
+			})
 		}
 	}
-	err := <-erc
+	units := f.units
+	f.m.Unlock()
+
+	var wg sync.WaitGroup
+	for _, u := range units {
+		wg.Add(1)
+		go func(u *unit) {
+			defer wg.Done()
+			f.supervise(ctx, u)
+		}(u)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		f.log.Info("fscrub interrupted, stopping handlers")
+	}
+	f.stop()
+
+	err := f.result()
 	if err != nil {
 		f.log.Error("fscrub handler error", zap.Error(err))
 		return errors.Wrap(err, "error encountered while running fscrub")
@@ -55,3 +92,41 @@ func (f *FsHandler) Run() error {
 	f.log.Info("fscrub finished")
 	return nil
 }
+
+// Status of all handlers of the current or last run, ordered by dir and handler
+func (f *FsHandler) Status() []Status {
+	f.m.Lock()
+	defer f.m.Unlock()
+	status := make([]Status, len(f.units))
+	for i, u := range f.units {
+		status[i] = u.status
+	}
+	return status
+}
+
+// stop every handler once, waiting for the files they have in progress
+func (f *FsHandler) stop() {
+	stopped := make(map[model.Handler]bool)
+	for _, h := range f.Handlers {
+		if stopped[h] {
+			continue
+		}
+		stopped[h] = true
+		f.log.Info("stopping handler", zap.String("type", reflect.TypeOf(h).String()))
+		h.Stop()
+	}
+}
+
+// result of the run, the aggregate of all failed handlers
+func (f *FsHandler) result() error {
+	var failures []Status
+	for _, s := range f.Status() {
+		if s.State == StateFailed {
+			failures = append(failures, s)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &HandlerError{Failures: failures}
+}
@@ -1,7 +1,9 @@
 package fshandle
 
 import (
+	"context"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -89,7 +91,7 @@ func TestFsHandler_Run(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.f.Run(); (err != nil) != tt.wantErr {
+			if err := tt.f.Run(context.Background()); (err != nil) != tt.wantErr {
 				t.Errorf("Fscrub.Run() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -98,7 +100,7 @@ func TestFsHandler_Run(t *testing.T) {
 
 type mockHandler struct{}
 
-func (h *mockHandler) Run(dir model.Directory, erc chan error) {
+func (h *mockHandler) Run(ctx context.Context, dir model.Directory, erc chan error) {
 	if len(dir) < 1 {
 		erc <- errors.New("invalid dir")
 	}
@@ -111,7 +113,7 @@ func (h *mockHandler) Run(dir model.Directory, erc chan error) {
 
 func (h *mockHandler) Stop() {}
 
-func mockAction(path string, file os.FileInfo) error {
+func mockAction(ctx context.Context, path string, file os.FileInfo) error {
 	if len(path) < 1 {
 		return errors.New("invalid path")
 	}
@@ -120,3 +122,139 @@ func mockAction(path string, file os.FileInfo) error {
 	}
 	return nil
 }
+
+// lifecycleHandler fails for the first fails runs of a dir, afterwards it is done right away
+// or, if it is blocking, runs until ctx is done
+type lifecycleHandler struct {
+	fails    int
+	blocking bool
+
+	m     sync.Mutex
+	runs  map[model.Directory]int
+	stops int
+}
+
+func (h *lifecycleHandler) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	h.m.Lock()
+	if h.runs == nil {
+		h.runs = make(map[model.Directory]int)
+	}
+	h.runs[dir]++
+	run := h.runs[dir]
+	h.m.Unlock()
+	if run <= h.fails {
+		erc <- errors.Errorf("run %d failed", run)
+		return
+	}
+	if h.blocking {
+		<-ctx.Done()
+		return
+	}
+	erc <- nil
+}
+
+func (h *lifecycleHandler) Stop() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.stops++
+}
+
+func TestFsHandler_Lifecycle(t *testing.T) {
+	done := &lifecycleHandler{}
+	watching := &lifecycleHandler{blocking: true}
+	f := NewFsHandler(model.Directories{"a", "b"}, []model.Handler{done, watching}, log.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan error, 1)
+	go func() {
+		returned <- f.Run(ctx)
+	}()
+	// handlers being done must not end the others
+	time.Sleep(time.Millisecond * 50)
+	select {
+	case err := <-returned:
+		t.Fatalf("FsHandler.Run() = %v before all handlers were done", err)
+	default:
+	}
+	cancel()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Errorf("FsHandler.Run() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("FsHandler.Run() did not return once ctx was done")
+	}
+
+	// ordered by dir and handler
+	want := []State{StateDone, StateStopped, StateDone, StateStopped}
+	status := f.Status()
+	for i, s := range status {
+		if s.State != want[i] {
+			t.Errorf("Status() of handler %d for %s = %v, want %v", i%2, s.Dir, s.State, want[i])
+		}
+	}
+	if done.stops != 1 || watching.stops != 1 {
+		t.Errorf("handlers stopped %d and %d times, want once", done.stops, watching.stops)
+	}
+}
+
+func TestFsHandler_Restart(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       RestartPolicy
+		restarts     int
+		fails        int
+		wantState    State
+		wantRestarts int
+	}{
+		{"never", RestartNever, 3, 1, StateFailed, 0},
+		{"onFailure", RestartOnFailure, 3, 2, StateDone, 2},
+		{"exhausted", RestartOnFailure, 1, 3, StateFailed, 1},
+		{"notFailing", RestartOnFailure, 3, 0, StateDone, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &lifecycleHandler{fails: tt.fails}
+			f := NewFsHandler(model.Directories{"a"}, []model.Handler{h}, log.NewNop()).
+				WithRestartPolicy(tt.policy).
+				WithRestarts(tt.restarts, time.Millisecond)
+			err := f.Run(context.Background())
+			if _, failed := errors.Cause(err).(*HandlerError); failed != (tt.wantState == StateFailed) {
+				t.Errorf("FsHandler.Run() error = %v, want state %v", err, tt.wantState)
+			}
+			status := f.Status()
+			if len(status) != 1 || status[0].State != tt.wantState || status[0].Restarts != tt.wantRestarts {
+				t.Errorf("Status() = %+v, want %v after %d restarts", status, tt.wantState, tt.wantRestarts)
+			}
+		})
+	}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	for _, p := range []RestartPolicy{RestartNever, RestartOnFailure} {
+		got, err := ParseRestartPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseRestartPolicy(%q) = %v, %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParseRestartPolicy("always"); err == nil {
+		t.Errorf("ParseRestartPolicy(always) error = nil")
+	}
+}
+
+func TestMux(t *testing.T) {
+	fallback := &lifecycleHandler{}
+	polling := &lifecycleHandler{fails: 1}
+	m := NewMux(fallback).Handle("b", polling).Handle("c", polling)
+	f := NewFsHandler(model.Directories{"a", "b", "c"}, []model.Handler{m}, log.NewNop())
+	if err := f.Run(context.Background()); err == nil {
+		t.Errorf("FsHandler.Run() error = nil, want the failures of the routed handler")
+	}
+	if fallback.runs["a"] != 1 || fallback.runs["b"] != 0 || polling.runs["b"] != 1 || polling.runs["c"] != 1 {
+		t.Errorf("Mux.Run() routed %v to the fallback and %v to the handler", fallback.runs, polling.runs)
+	}
+	if fallback.stops != 1 || polling.stops != 1 {
+		t.Errorf("handlers stopped %d and %d times, want once", fallback.stops, polling.stops)
+	}
+}
@@ -0,0 +1,190 @@
+package fshandle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+)
+
+// State of a handler running for a dir
+type State int
+
+const (
+	// StateRunning handlers are running or waiting to be restarted
+	StateRunning State = iota
+	// StateDone handlers returned without reporting an error
+	StateDone
+	// StateFailed handlers reported an error and are not restarted anymore
+	StateFailed
+	// StateStopped handlers were stopped before they were done
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDone:
+		return "done"
+	case StateFailed:
+		return "failed"
+	case StateStopped:
+		return "stopped"
+	}
+	return "running"
+}
+
+// Status of a handler running for a dir
+type Status struct {
+	Dir     model.Directory
+	Handler string
+	State   State
+	// Err reported by the last failed run
+	Err error
+	// Restarts after failed runs
+	Restarts int
+}
+
+// HandlerError lists all handlers which failed for their dir
+type HandlerError struct {
+	Failures []Status
+}
+
+func (e *HandlerError) Error() string {
+	listed := make([]string, len(e.Failures))
+	for i, s := range e.Failures {
+		listed[i] = fmt.Sprintf("%s for %s: %v", s.Handler, s.Dir, s.Err)
+	}
+	return fmt.Sprintf("%d handlers failed: %s", len(e.Failures), strings.Join(listed, "; "))
+}
+
+// RestartPolicy defines how failed handlers are treated
+type RestartPolicy int
+
+const (
+	// RestartNever leaves failed handlers failed
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure runs failed handlers again with growing delays
+	RestartOnFailure
+)
+
+// defaults of RestartOnFailure
+const (
+	DefaultRestarts       = 3
+	DefaultRestartBackoff = time.Second
+)
+
+// ParseRestartPolicy from its flag representation
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	switch s {
+	case "never", "":
+		return RestartNever, nil
+	case "on-failure":
+		return RestartOnFailure, nil
+	}
+	return RestartNever, fmt.Errorf("unsupported restart policy %q", s)
+}
+
+func (p RestartPolicy) String() string {
+	if p == RestartOnFailure {
+		return "on-failure"
+	}
+	return "never"
+}
+
+// WithRestartPolicy sets how failed handlers are treated
+func (f *FsHandler) WithRestartPolicy(p RestartPolicy) *FsHandler {
+	f.restartPolicy = p
+	return f
+}
+
+// WithRestarts sets how often a failed handler is restarted for a dir with RestartOnFailure
+// and the delay before the first restart, which doubles with every further one
+func (f *FsHandler) WithRestarts(restarts int, backoff time.Duration) *FsHandler {
+	f.restarts = restarts
+	f.backoff = backoff
+	return f
+}
+
+// unit is a handler running for a single dir
+type unit struct {
+	handler model.Handler
+	// status is guarded by the mutex of the FsHandler
+	status Status
+}
+
+// supervise runs the handler of u for its dir, restarting it according to the restart policy
+func (f *FsHandler) supervise(ctx context.Context, u *unit) {
+	dir, name := u.status.Dir.String(), u.status.Handler
+	for restarts := 0; ; restarts++ {
+		f.log.Info("starting handler", zap.String("type", name), zap.String("dir", dir))
+		err := f.run(ctx, u)
+		if ctx.Err() != nil {
+			f.log.Info("handler stopped", zap.String("type", name), zap.String("dir", dir))
+			f.set(u, StateStopped, u.status.Err)
+			return
+		}
+		if err == nil {
+			f.log.Info("handler done", zap.String("type", name), zap.String("dir", dir))
+			f.set(u, StateDone, nil)
+			return
+		}
+		f.log.Error("handler failed", zap.String("type", name), zap.String("dir", dir), zap.Error(err))
+		f.set(u, StateFailed, err)
+		if f.restartPolicy != RestartOnFailure || restarts >= f.restarts {
+			return
+		}
+
+		delay := f.backoff << uint(restarts)
+		f.log.Warn("restarting failed handler",
+			zap.String("type", name),
+			zap.String("dir", dir),
+			zap.Int("restart", restarts+1),
+			zap.Duration("delay", delay),
+		)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		f.m.Lock()
+		u.status.State = StateRunning
+		u.status.Restarts++
+		f.m.Unlock()
+	}
+}
+
+// run the handler of u once, returning the first error it reported
+// Handlers reporting an error are cancelled, as they are not expected to continue
+func (f *FsHandler) run(ctx context.Context, u *unit) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	erc := make(chan error)
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		u.handler.Run(ctx, u.status.Dir, erc)
+	}()
+	var failure error
+	for {
+		select {
+		case err := <-erc:
+			if err != nil && failure == nil {
+				failure = err
+				cancel()
+			}
+		case <-returned:
+			return failure
+		}
+	}
+}
+
+func (f *FsHandler) set(u *unit, state State, err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	u.status.State = state
+	u.status.Err = err
+}
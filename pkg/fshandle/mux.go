@@ -0,0 +1,53 @@
+package fshandle
+
+import (
+	"context"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+)
+
+// Mux is a handler routing dirs to the handler registered for them, all other dirs are passed to the fallback
+// It allows e.g. polling dirs on network file systems while watching all others
+type Mux struct {
+	fallback model.Handler
+	dirs     map[model.Directory]model.Handler
+}
+
+// NewMux passing all dirs without registered handler to fallback
+func NewMux(fallback model.Handler) *Mux {
+	return &Mux{
+		fallback: fallback,
+		dirs:     make(map[model.Directory]model.Handler),
+	}
+}
+
+// Handle dir with h
+func (m *Mux) Handle(dir model.Directory, h model.Handler) *Mux {
+	m.dirs[dir] = h
+	return m
+}
+
+func (m *Mux) route(dir model.Directory) model.Handler {
+	if h, ok := m.dirs[dir]; ok {
+		return h
+	}
+	return m.fallback
+}
+
+// Run the handler of dir
+func (m *Mux) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	m.route(dir).Run(ctx, dir, erc)
+}
+
+// Stop every handler once
+func (m *Mux) Stop() {
+	stopped := map[model.Handler]bool{m.fallback: true}
+	m.fallback.Stop()
+	for _, h := range m.dirs {
+		if stopped[h] {
+			continue
+		}
+		stopped[h] = true
+		h.Stop()
+	}
+}
@@ -0,0 +1,117 @@
+package fsignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+)
+
+// FileName of ignore files
+const FileName = ".fscrubignore"
+
+// Matcher decides whether paths are excluded by ignore files
+// Like .gitignore, an ignore file applies to its dir and everything below, deeper files take precedence
+// and paths inside an ignored dir can not be included again
+// Ignore files are read once and cached until they get invalidated. A nil Matcher ignores nothing
+type Matcher struct {
+	log *log.Logger
+	fs  vfs.Filesystem
+
+	m     sync.Mutex
+	files map[string][]*pattern
+}
+
+// NewMatcher reading ignore files from fs
+func NewMatcher(log *log.Logger, fs vfs.Filesystem) *Matcher {
+	return &Matcher{
+		log:   log,
+		fs:    fs,
+		files: make(map[string][]*pattern),
+	}
+}
+
+// Ignored reports whether path is excluded by the ignore files found in root or the dirs between root and path
+func (m *Matcher) Ignored(root, path string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	root = filepath.Clean(root)
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	// a path is ignored if any of its parents is
+	for i := range parts {
+		if m.match(root, parts[:i+1], i < len(parts)-1 || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// match checks the path made of parts against the ignore files of root and all parents of the path
+// The last matching pattern wins
+func (m *Matcher) match(root string, parts []string, isDir bool) bool {
+	ignored := false
+	dir := root
+	for i := range parts {
+		rel := strings.Join(parts[i:], "/")
+		for _, p := range m.patterns(dir) {
+			if p.match(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+// patterns of the ignore file in dir
+func (m *Matcher) patterns(dir string) []*pattern {
+	m.m.Lock()
+	patterns, ok := m.files[dir]
+	m.m.Unlock()
+	if ok {
+		return patterns
+	}
+
+	path := filepath.Join(dir, FileName)
+	data, err := vfs.ReadFile(m.fs, path)
+	if err == nil {
+		patterns = parse(data)
+		m.log.Debug("loaded ignore file", zap.String("file", path), zap.Int("patterns", len(patterns)))
+	} else if !os.IsNotExist(err) {
+		m.log.Error("unable to read ignore file", zap.String("file", path), zap.Error(err))
+	}
+	m.m.Lock()
+	m.files[dir] = patterns
+	m.m.Unlock()
+	return patterns
+}
+
+// Invalidate the cached ignore files of dir and all dirs below it, so they are read again when needed
+func (m *Matcher) Invalidate(dir string) {
+	if m == nil {
+		return
+	}
+	dir = filepath.Clean(dir)
+	prefix := dir + string(filepath.Separator)
+	m.m.Lock()
+	defer m.m.Unlock()
+	for d := range m.files {
+		if d == dir || strings.HasPrefix(d, prefix) {
+			delete(m.files, d)
+		}
+	}
+}
+
+// IsIgnoreFile reports whether path is an ignore file
+func IsIgnoreFile(path string) bool {
+	return filepath.Base(path) == FileName
+}
@@ -0,0 +1,117 @@
+package fsignore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		path    string
+		isDir   bool
+		want    bool
+		wantNeg bool
+	}{
+		{"name", "*.log", "a/b/c.log", false, true, false},
+		{"nameNoMatch", "*.log", "a/b/c.txt", false, false, false},
+		{"starNoSlash", "a*", "ab/c", false, false, false},
+		{"anchored", "/build", "build", true, true, false},
+		{"anchoredDeep", "/build", "sub/build", true, false, false},
+		{"middleSlash", "doc/*.txt", "doc/a.txt", false, true, false},
+		{"middleSlashDeep", "doc/*.txt", "sub/doc/a.txt", false, false, false},
+		{"dirOnly", "cache/", "cache", true, true, false},
+		{"dirOnlyFile", "cache/", "cache", false, false, false},
+		{"dirOnlyDeep", "cache/", "a/cache", true, true, false},
+		{"leadingStars", "**/logs", "a/b/logs", true, true, false},
+		{"leadingStarsTop", "**/logs", "logs", true, true, false},
+		{"trailingStars", "abc/**", "abc/x/y", false, true, false},
+		{"trailingStarsSelf", "abc/**", "abc", true, false, false},
+		{"middleStars", "a/**/b", "a/x/y/b", false, true, false},
+		{"middleStarsNone", "a/**/b", "a/b", false, true, false},
+		{"question", "?.log", "a.log", false, true, false},
+		{"class", "[ab].log", "b.log", false, true, false},
+		{"negatedClass", "[!ab].log", "b.log", false, false, false},
+		{"negate", "!keep.log", "keep.log", false, true, true},
+		{"escapedBang", `\!important`, "!important", false, true, false},
+		{"escapedHash", `\#file`, "#file", false, true, false},
+		{"trailingSpace", "a.log  ", "a.log", false, true, false},
+		{"escapedSpace", `a.log\ `, "a.log ", false, true, false},
+		{"dot", "a.log", "aXlog", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := compile(tt.line)
+			if !ok {
+				t.Fatalf("compile(%q) failed", tt.line)
+			}
+			if got := p.match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("compile(%q).match(%q) = %v, want %v", tt.line, tt.path, got, tt.want)
+			}
+			if p.negate != tt.wantNeg {
+				t.Errorf("compile(%q).negate = %v, want %v", tt.line, p.negate, tt.wantNeg)
+			}
+		})
+	}
+	for _, line := range []string{"", "   ", "# comment", "!", "/"} {
+		if _, ok := compile(line); ok {
+			t.Errorf("compile(%q) = ok, want skipped", line)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	fs := vfs.NewMem()
+	files := map[string]string{
+		"data/.fscrubignore":     "*.tmp\n/cache/\nsecret*\n!secret.log\n",
+		"data/sub/.fscrubignore": "# deeper files take precedence\n!*.tmp\nnested/\n",
+	}
+	for name, content := range files {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewMatcher(log.NewNop(), fs)
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"data", true, false},
+		{"data/a.log", false, false},
+		{"data/a.tmp", false, true},
+		{"data/cache", true, true},
+		{"data/cache/a.log", false, true},
+		{"data/sub/cache", true, false},
+		{"data/secret.txt", false, true},
+		{"data/secret.log", false, false},
+		{"data/sub/b.tmp", false, false},
+		{"data/sub/nested/b.log", false, true},
+		{"data/sub/deeper/secret.key", false, true},
+		{"other/a.tmp", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.Ignored("data", tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Matcher.Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// changes are picked up once invalidated
+	if err := fs.WriteFile("data/sub/.fscrubignore", []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m.Ignored("data", "data/sub/c.log", false) {
+		t.Errorf("Matcher.Ignored() reloaded without invalidation")
+	}
+	m.Invalidate("data/sub")
+	if !m.Ignored("data", "data/sub/c.log", false) || !m.Ignored("data", "data/sub/b.tmp", false) {
+		t.Errorf("Matcher.Ignored() did not reload invalidated ignore file")
+	}
+}
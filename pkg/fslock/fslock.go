@@ -0,0 +1,74 @@
+package fslock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+)
+
+// Locker serializes the handling of each path, so concurrent workers or handlers never process the same file at once
+// Different paths do not block each other
+type Locker struct {
+	m     sync.Mutex
+	paths map[string]*pathLock
+}
+
+// pathLock is the lock of a single path, dropped once nobody holds or waits for it
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New Locker
+func New() *Locker {
+	return &Locker{paths: make(map[string]*pathLock)}
+}
+
+// Lock path, blocking until it is available
+func (l *Locker) Lock(path string) {
+	path = filepath.Clean(path)
+	l.m.Lock()
+	pl, ok := l.paths[path]
+	if !ok {
+		pl = &pathLock{}
+		l.paths[path] = pl
+	}
+	pl.refs++
+	l.m.Unlock()
+	pl.Lock()
+}
+
+// Unlock path
+func (l *Locker) Unlock(path string) {
+	path = filepath.Clean(path)
+	l.m.Lock()
+	pl, ok := l.paths[path]
+	if !ok {
+		l.m.Unlock()
+		panic("fslock: unlock of unlocked path " + path)
+	}
+	pl.refs--
+	if pl.refs == 0 {
+		delete(l.paths, path)
+	}
+	l.m.Unlock()
+	pl.Unlock()
+}
+
+// Action runs actions while holding the lock of the path they are run for
+// Handlers sharing the returned action never handle the same path concurrently
+func (l *Locker) Action(actions ...model.Action) model.Action {
+	return func(ctx context.Context, path string, file os.FileInfo) error {
+		l.Lock(path)
+		defer l.Unlock(path)
+		for _, a := range actions {
+			if err := a(ctx, path, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package fslock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	l := New()
+	var m sync.Mutex
+	active := make(map[string]int)
+	maxActive := make(map[string]int)
+	action := l.Action(func(ctx context.Context, path string, file os.FileInfo) error {
+		path = filepath.Clean(path)
+		m.Lock()
+		active[path]++
+		if active[path] > maxActive[path] {
+			maxActive[path] = active[path]
+		}
+		m.Unlock()
+		time.Sleep(time.Millisecond)
+		m.Lock()
+		active[path]--
+		m.Unlock()
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, path := range []string{"a.log", "./a.log", "b.log"} {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				action(context.Background(), path, nil)
+			}(path)
+		}
+	}
+	wg.Wait()
+	if maxActive["a.log"] != 1 || maxActive["b.log"] != 1 {
+		t.Errorf("path handled concurrently %v", maxActive)
+	}
+	if len(l.paths) != 0 {
+		t.Errorf("Locker kept %d unused locks", len(l.paths))
+	}
+
+	// different paths do not block each other
+	l.Lock("a.log")
+	done := make(chan struct{})
+	go func() {
+		l.Lock("b.log")
+		l.Unlock("b.log")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Locker.Lock() blocked on another path")
+	}
+	l.Unlock("a.log")
+}
@@ -1,6 +1,7 @@
 package fslog
 
 import (
+	"context"
 	"os"
 
 	"github.com/playnet-public/libs/log"
@@ -21,7 +22,7 @@ func NewFsLogger(log *log.Logger) *FsLogger {
 }
 
 // Log the provided path and file
-func (f *FsLogger) Log(path string, file os.FileInfo) error {
+func (f *FsLogger) Log(ctx context.Context, path string, file os.FileInfo) error {
 	f.log.Info(
 		"running action",
 		zap.String("action", "fslog"),
@@ -0,0 +1,138 @@
+package fspolicy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+)
+
+// FileName of policy files
+const FileName = ".fscrub.yml"
+
+// IsPolicyFile reports whether path is a policy file
+func IsPolicyFile(path string) bool {
+	return filepath.Base(path) == FileName
+}
+
+// Resolver computes the policy of files by merging the policy files of all dirs from the root down to the file
+// Policy files are read once and cached until they get invalidated
+type Resolver struct {
+	log   *log.Logger
+	fs    vfs.Filesystem
+	roots []string
+
+	m     sync.Mutex
+	files map[string]*policyFile
+}
+
+// policyFile is a cached policy file, file is nil if the dir has none
+type policyFile struct {
+	file *File
+	err  error
+}
+
+// NewResolver reading policy files from fs
+// Policies cascade from the root containing a file, files outside all roots only get the policy of their own dir
+func NewResolver(log *log.Logger, fs vfs.Filesystem, roots ...string) *Resolver {
+	r := &Resolver{
+		log:   log,
+		fs:    fs,
+		files: make(map[string]*policyFile),
+	}
+	for _, root := range roots {
+		r.roots = append(r.roots, filepath.Clean(root))
+	}
+	return r
+}
+
+// Resolve the policy of the file at path
+// Invalid policy files are reported as error, as it is unknown how their subtree should be treated
+func (r *Resolver) Resolve(path string) (Policy, error) {
+	policy := Default
+	for _, dir := range r.chain(filepath.Dir(filepath.Clean(path))) {
+		pf := r.load(dir)
+		if pf.err != nil {
+			return policy, pf.err
+		}
+		if pf.file != nil {
+			policy = policy.Merge(*pf.file)
+		}
+	}
+	return policy, nil
+}
+
+// chain returns all dirs from the root containing dir down to dir
+func (r *Resolver) chain(dir string) []string {
+	root := ""
+	for _, rt := range r.roots {
+		if primitives.Within(dir, rt) && len(rt) >= len(root) {
+			root = rt
+		}
+	}
+	if root == "" {
+		return []string{dir}
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return []string{root}
+	}
+	chain := []string{root}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		chain = append(chain, current)
+	}
+	return chain
+}
+
+func (r *Resolver) load(dir string) *policyFile {
+	r.m.Lock()
+	pf, ok := r.files[dir]
+	r.m.Unlock()
+	if ok {
+		return pf
+	}
+
+	pf = &policyFile{}
+	path := filepath.Join(dir, FileName)
+	data, err := vfs.ReadFile(r.fs, path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		pf.err = errors.Wrapf(err, "reading policy %s failed", path)
+	default:
+		file, err := Parse(data)
+		if err != nil {
+			pf.err = errors.Wrapf(err, "invalid policy %s", path)
+			break
+		}
+		pf.file = &file
+		r.log.Debug("loaded policy", zap.String("file", path))
+	}
+	if pf.err != nil {
+		r.log.Error("unable to load policy", zap.String("file", path), zap.Error(pf.err))
+	}
+	r.m.Lock()
+	r.files[dir] = pf
+	r.m.Unlock()
+	return pf
+}
+
+// Invalidate the cached policy files of dir and all dirs below it, so they are read again when needed
+func (r *Resolver) Invalidate(dir string) {
+	dir = filepath.Clean(dir)
+	r.m.Lock()
+	defer r.m.Unlock()
+	for d := range r.files {
+		if primitives.Within(d, dir) {
+			delete(r.files, d)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package fspolicy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestParse(t *testing.T) {
+	no := false
+	tests := []struct {
+		name    string
+		content string
+		want    File
+		wantErr bool
+	}{
+		{"empty", "", File{}, false},
+		{
+			"full",
+			"inherit: false\npatterns: [intelligentIP, secrets]\ndisable-patterns: [secrets]\naction: report\nheader: false\n",
+			File{Inherit: &no, Patterns: []string{"intelligentIP", "secrets"}, DisablePatterns: []string{"secrets"}, Action: ActionReport, Header: &no},
+			false,
+		},
+		{"unknownField", "pattern: [secrets]\n", File{}, true},
+		{"unknownAction", "action: delete\n", File{}, true},
+		{"invalid", "patterns: [\n", File{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Selects(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		id     string
+		want   bool
+	}{
+		{"all", Default, "secrets", true},
+		{"selected", Policy{Patterns: []string{"secrets"}}, "secrets", true},
+		{"notSelected", Policy{Patterns: []string{"secrets"}}, "intelligentIP", false},
+		{"none", Policy{Patterns: []string{}}, "secrets", false},
+		{"disabled", Policy{Disabled: []string{"secrets"}}, "secrets", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Selects(tt.id); got != tt.want {
+				t.Errorf("Policy.Selects(%q) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	fs := vfs.NewMem()
+	files := map[string]string{
+		"data/.fscrub.yml":             "disable-patterns: [extra]\n",
+		"data/logs/.fscrub.yml":        "patterns: [intelligentIP, secrets]\n",
+		"data/logs/app/.fscrub.yml":    "disable-patterns: [intelligentIP]\nheader: false\n",
+		"data/public/.fscrub.yml":      "patterns: [secrets]\naction: report\n",
+		"data/public/raw/.fscrub.yml":  "inherit: false\n",
+		"data/staff/.fscrub.yml":       "action: skip\n",
+		"data/broken/.fscrub.yml":      "action: [\n",
+		"outside/.fscrub.yml":          "action: skip\n",
+		"data/logs/app/deep/file.log":  "",
+		"data/public/raw/deep/file.md": "",
+	}
+	for name, content := range files {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewResolver(log.NewNop(), fs, "data")
+	tests := []struct {
+		path    string
+		want    Policy
+		wantErr bool
+	}{
+		{"data/a.log", Policy{Disabled: []string{"extra"}, Action: ActionScrub, Header: true}, false},
+		{"data/logs/a.log", Policy{Patterns: []string{"intelligentIP", "secrets"}, Action: ActionScrub, Header: true}, false},
+		{"data/logs/app/deep/file.log", Policy{Patterns: []string{"intelligentIP", "secrets"}, Disabled: []string{"intelligentIP"}, Action: ActionScrub}, false},
+		{"data/public/a.md", Policy{Patterns: []string{"secrets"}, Action: ActionReport, Header: true}, false},
+		{"data/public/raw/deep/file.md", Default, false},
+		{"data/staff/a.txt", Policy{Disabled: []string{"extra"}, Action: ActionSkip, Header: true}, false},
+		{"data/broken/a.txt", Policy{}, true},
+		{"outside/a.txt", Policy{Action: ActionSkip, Header: true}, false},
+		{"other/a.txt", Default, false},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolver.Resolve(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolver.Resolve(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+
+	// changes are picked up once invalidated
+	if err := fs.WriteFile("data/staff/.fscrub.yml", []byte("action: report\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := r.Resolve("data/staff/a.txt"); p.Action != ActionSkip {
+		t.Errorf("Resolver.Resolve() reloaded without invalidation")
+	}
+	r.Invalidate("data/staff")
+	if p, _ := r.Resolve("data/staff/a.txt"); p.Action != ActionReport {
+		t.Errorf("Resolver.Resolve() = %+v after invalidation, want report", p)
+	}
+}
@@ -0,0 +1,103 @@
+package fspolicy
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Action taken for the files a policy applies to
+type Action string
+
+const (
+	// ActionScrub replaces all findings in files
+	ActionScrub Action = "scrub"
+	// ActionReport only logs and reports findings, files stay unchanged
+	ActionReport Action = "report"
+	// ActionSkip leaves files alone entirely
+	ActionSkip Action = "skip"
+)
+
+// File is the content of a policy file, fields not set are inherited from the policies of parent dirs
+type File struct {
+	// Inherit set to false starts over from the default policy instead of the parent policies
+	Inherit *bool `yaml:"inherit"`
+	// Patterns selects the ids of the patterns to apply, replacing the inherited selection
+	Patterns []string `yaml:"patterns"`
+	// DisablePatterns drops patterns from the inherited selection
+	DisablePatterns []string `yaml:"disable-patterns"`
+	Action          Action   `yaml:"action"`
+	// Header adds the fscrub header to changed files
+	Header *bool `yaml:"header"`
+}
+
+// Parse a policy file, unknown fields are rejected so typos do not go unnoticed
+func Parse(data []byte) (File, error) {
+	var f File
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return File{}, err
+	}
+	switch f.Action {
+	case "", ActionScrub, ActionReport, ActionSkip:
+	default:
+		return File{}, fmt.Errorf("unsupported action %q", f.Action)
+	}
+	return f, nil
+}
+
+// Policy is the effective policy of a file after merging all policy files above it
+type Policy struct {
+	// Patterns selects the ids of the patterns to apply, nil selects all
+	Patterns []string
+	// Disabled pattern ids, even if selected
+	Disabled []string
+	Action   Action
+	Header   bool
+}
+
+// Default policy scrubbing files with all patterns
+var Default = Policy{Action: ActionScrub, Header: true}
+
+// Merge the policy file f of a subdir into p
+func (p Policy) Merge(f File) Policy {
+	if f.Inherit != nil && !*f.Inherit {
+		p = Default
+	}
+	if f.Patterns != nil {
+		p.Patterns = append([]string{}, f.Patterns...)
+		p.Disabled = nil
+	}
+	if len(f.DisablePatterns) > 0 {
+		p.Disabled = append(append([]string{}, p.Disabled...), f.DisablePatterns...)
+	}
+	if f.Action != "" {
+		p.Action = f.Action
+	}
+	if f.Header != nil {
+		p.Header = *f.Header
+	}
+	return p
+}
+
+// Selects reports whether the pattern with id is applied
+func (p Policy) Selects(id string) bool {
+	for _, d := range p.Disabled {
+		if d == id {
+			return false
+		}
+	}
+	if p.Patterns == nil {
+		return true
+	}
+	for _, s := range p.Patterns {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies the pattern selection of the policy
+func (p Policy) Key() string {
+	return fmt.Sprintf("%v|%q|%q", p.Patterns == nil, p.Patterns, p.Disabled)
+}
@@ -0,0 +1,84 @@
+package fsreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Actions taken on findings
+const (
+	ActionReplaced = "replaced"
+	ActionDetected = "detected"
+	// ActionFailed marks findings which should have been replaced, but scrubbing or writing the file failed
+	ActionFailed = "failed"
+)
+
+// Finding describes a single match of a pattern inside a file
+// Lines and columns are 1-based, EndColumn points behind the last matched character
+type Finding struct {
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	StartColumn int    `json:"startColumn"`
+	EndColumn   int    `json:"endColumn"`
+	Rule        string `json:"rule"`
+	Severity    string `json:"severity"`
+	Action      string `json:"action"`
+	Preview     string `json:"preview"`
+}
+
+// Reporter records findings
+type Reporter interface {
+	Report(f Finding) error
+	Close() error
+}
+
+// NopReporter discards all findings
+type NopReporter struct{}
+
+// Report nothing
+func (r NopReporter) Report(f Finding) error {
+	return nil
+}
+
+// Close nothing
+func (r NopReporter) Close() error {
+	return nil
+}
+
+// New returns the reporter for format writing to w
+func New(format string, w io.Writer, version string) (Reporter, error) {
+	switch format {
+	case "jsonl", "json":
+		return NewJSONLines(w), nil
+	case "sarif":
+		return NewSARIF(w, version), nil
+	}
+	return nil, fmt.Errorf("unsupported report format %q", format)
+}
+
+// JSONLines writes one json object per finding
+type JSONLines struct {
+	m   sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLines writing to w
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Report writes the finding as a single line
+func (r *JSONLines) Report(f Finding) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.enc.Encode(f)
+}
+
+// Close the reporter
+func (r *JSONLines) Close() error {
+	return nil
+}
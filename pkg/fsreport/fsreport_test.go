@@ -0,0 +1,97 @@
+package fsreport
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var testFinding = Finding{
+	File:        "logs/test.log",
+	Line:        3,
+	StartColumn: 5,
+	EndColumn:   14,
+	Rule:        "intelligentIP",
+	Severity:    "high",
+	Action:      ActionReplaced,
+	Preview:     "GET *** 200",
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr bool
+	}{
+		{"jsonl", "jsonl", false},
+		{"sarif", "sarif", false},
+		{"unknown", "xml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.format, &bytes.Buffer{}, ""); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewJSONLines(&buf)
+	for i := 0; i < 2; i++ {
+		if err := r.Report(testFinding); err != nil {
+			t.Errorf("JSONLines.Report() error = %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("JSONLines.Close() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("JSONLines wrote %d lines, want 2", len(lines))
+	}
+	var got Finding
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("JSONLines wrote invalid json: %v", err)
+	}
+	if got != testFinding {
+		t.Errorf("JSONLines.Report() = %v, want %v", got, testFinding)
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewSARIF(&buf, "v1.0.0")
+	if err := r.Report(testFinding); err != nil {
+		t.Errorf("SARIF.Report() error = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("SARIF.Report() wrote before Close")
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("SARIF.Close() error = %v", err)
+	}
+	var got sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("SARIF wrote invalid json: %v", err)
+	}
+	if got.Version != sarifVersion || len(got.Runs) != 1 {
+		t.Fatalf("SARIF log = %+v, want single %s run", got, sarifVersion)
+	}
+	run := got.Runs[0]
+	if len(run.Tool.Driver.Rules) != 1 || run.Tool.Driver.Rules[0].ID != testFinding.Rule {
+		t.Errorf("SARIF rules = %v, want %v", run.Tool.Driver.Rules, testFinding.Rule)
+	}
+	if len(run.Results) != 1 {
+		t.Fatalf("SARIF results = %v, want 1", len(run.Results))
+	}
+	if run.Results[0].Level != "error" {
+		t.Errorf("SARIF level = %v, want error", run.Results[0].Level)
+	}
+	region := run.Results[0].Locations[0].PhysicalLocation.Region
+	if region.StartLine != 3 || region.StartColumn != 5 || region.EndColumn != 14 || region.Snippet.Text != testFinding.Preview {
+		t.Errorf("SARIF region = %+v, want finding %+v", region, testFinding)
+	}
+}
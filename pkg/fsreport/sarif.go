@@ -0,0 +1,164 @@
+package fsreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolURI      = "https://github.com/playnet-public/fscrub"
+)
+
+// SARIF collects findings and writes them as a single SARIF 2.1.0 log on Close
+type SARIF struct {
+	m        sync.Mutex
+	w        io.Writer
+	version  string
+	findings []Finding
+}
+
+// NewSARIF writing to w once closed
+func NewSARIF(w io.Writer, version string) *SARIF {
+	return &SARIF{
+		w:       w,
+		version: version,
+	}
+}
+
+// Report stores the finding until the log gets written
+func (r *SARIF) Report(f Finding) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.findings = append(r.findings, f)
+	return nil
+}
+
+// Close writes the log containing all reported findings
+func (r *SARIF) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.log())
+}
+
+func (r *SARIF) log() sarifLog {
+	ruleSet := make(map[string]bool)
+	results := []sarifResult{}
+	for _, f := range r.findings {
+		ruleSet[f.Rule] = true
+		results = append(results, sarifResult{
+			RuleID:  f.Rule,
+			Level:   sarifLevel(f.Severity),
+			Message: sarifMessage{Text: fmt.Sprintf("sensitive data matching %s (%s)", f.Rule, f.Action)},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(f.File)},
+					Region: sarifRegion{
+						StartLine:   f.Line,
+						StartColumn: f.StartColumn,
+						EndColumn:   f.EndColumn,
+						Snippet:     sarifMessage{Text: f.Preview},
+					},
+				},
+			}},
+			Properties: map[string]string{"action": f.Action},
+		})
+	}
+	rules := []sarifRule{}
+	for id := range ruleSet {
+		rules = append(rules, sarifRule{ID: id})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	return sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "fscrub",
+				InformationURI: toolURI,
+				Version:        r.version,
+				Rules:          rules,
+			}},
+			ColumnKind: "unicodeCodePoints",
+			Results:    results,
+		}},
+	}
+}
+
+// sarifLevel maps finding severities to SARIF result levels
+func sarifLevel(severity string) string {
+	switch severity {
+	case "high":
+		return "error"
+	case "low":
+		return "note"
+	}
+	return "warning"
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Version        string      `json:"version,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int          `json:"startLine"`
+	StartColumn int          `json:"startColumn,omitempty"`
+	EndColumn   int          `json:"endColumn,omitempty"`
+	Snippet     sarifMessage `json:"snippet"`
+}
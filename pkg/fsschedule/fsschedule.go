@@ -0,0 +1,175 @@
+package fsschedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+)
+
+// Status of the scheduled runs of a dir
+type Status struct {
+	// Running is set while a run is in progress
+	Running bool
+	// LastStart and LastEnd of the most recent run, zero if there was none yet
+	LastStart time.Time
+	LastEnd   time.Time
+	// LastErr returned by the most recent finished run
+	LastErr error
+	// Next activation, zero if there is none
+	Next time.Time
+	// Runs started and Skipped because the previous run was still going
+	Runs    int
+	Skipped int
+}
+
+// Scheduler is a handler rerunning another handler like the crawler on a schedule
+// Runs of a dir never overlap, activations during a run are skipped
+type Scheduler struct {
+	log       *log.Logger
+	handler   model.Handler
+	schedule  Schedule
+	schedules map[model.Directory]Schedule
+
+	m      sync.Mutex
+	status map[model.Directory]*Status
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	now      func() time.Time
+}
+
+// NewScheduler running handler for each dir on schedule
+func NewScheduler(log *log.Logger, handler model.Handler, schedule Schedule) *Scheduler {
+	return &Scheduler{
+		log:       log,
+		handler:   handler,
+		schedule:  schedule,
+		schedules: make(map[model.Directory]Schedule),
+		status:    make(map[model.Directory]*Status),
+		stop:      make(chan struct{}),
+		now:       time.Now,
+	}
+}
+
+// WithSchedule overrides the schedule for dir
+func (s *Scheduler) WithSchedule(dir model.Directory, schedule Schedule) *Scheduler {
+	s.schedules[dir] = schedule
+	return s
+}
+
+// Validate scheduler integrity
+func (s *Scheduler) Validate() error {
+	if s.log == nil {
+		return errors.New("log must not be nil")
+	}
+	if s.handler == nil {
+		return errors.New("handler must not be nil")
+	}
+	if s.schedule == nil {
+		return errors.New("schedule must not be nil")
+	}
+	return nil
+}
+
+// Status of dir, false if dir is not scheduled
+func (s *Scheduler) Status(dir model.Directory) (Status, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	status, ok := s.status[dir]
+	if !ok {
+		return Status{}, false
+	}
+	return *status, true
+}
+
+// Run the handler for dir on every activation of its schedule until stopped or ctx is done
+// Errors of single runs are logged and kept in the status, they do not end the scheduler
+func (s *Scheduler) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	schedule, ok := s.schedules[dir]
+	if !ok {
+		schedule = s.schedule
+	}
+	s.m.Lock()
+	status := &Status{}
+	s.status[dir] = status
+	s.m.Unlock()
+
+	s.log.Info("start handling", zap.String("dir", dir.String()), zap.String("handler", "scheduler"))
+	defer s.log.Info("stop handling", zap.String("dir", dir.String()), zap.String("handler", "scheduler"))
+
+	done := make(chan error, 1)
+	for {
+		next := schedule.Next(s.now())
+		s.m.Lock()
+		status.Next = next
+		s.m.Unlock()
+		if next.IsZero() {
+			s.log.Warn("schedule has no further activations", zap.String("dir", dir.String()))
+			select {
+			case <-s.stop:
+			case <-ctx.Done():
+			}
+			return
+		}
+		s.log.Info("next scheduled run", zap.String("dir", dir.String()), zap.Time("next", next))
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case err := <-done:
+			timer.Stop()
+			s.finish(dir, status, err)
+			continue
+		case <-timer.C:
+		}
+
+		s.m.Lock()
+		running := status.Running
+		if running {
+			status.Skipped++
+		} else {
+			status.Running = true
+			status.Runs++
+			status.LastStart = s.now()
+		}
+		s.m.Unlock()
+		if running {
+			s.log.Warn("skipping scheduled run, previous run still going", zap.String("dir", dir.String()))
+			continue
+		}
+		s.log.Info("starting scheduled run", zap.String("dir", dir.String()))
+		go s.handler.Run(ctx, dir, done)
+	}
+}
+
+func (s *Scheduler) finish(dir model.Directory, status *Status, err error) {
+	s.m.Lock()
+	status.Running = false
+	status.LastEnd = s.now()
+	status.LastErr = err
+	duration := status.LastEnd.Sub(status.LastStart)
+	s.m.Unlock()
+	if err != nil {
+		s.log.Error("scheduled run failed", zap.String("dir", dir.String()), zap.Error(err))
+		return
+	}
+	s.log.Info("scheduled run finished", zap.String("dir", dir.String()), zap.Duration("duration", duration))
+}
+
+// Stop scheduling and stop the handler
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.handler.Stop()
+	})
+}
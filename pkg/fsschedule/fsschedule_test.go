@@ -0,0 +1,149 @@
+package fsschedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2018, time.February, 10, 13, 37, 12, 0, time.UTC) // saturday
+	tests := []struct {
+		spec    string
+		want    time.Time
+		wantErr bool
+	}{
+		{"*/15 * * * *", time.Date(2018, 2, 10, 13, 45, 0, 0, time.UTC), false},
+		{"0 3 * * *", time.Date(2018, 2, 11, 3, 0, 0, 0, time.UTC), false},
+		{"30 8-10 * * mon-fri", time.Date(2018, 2, 12, 8, 30, 0, 0, time.UTC), false},
+		{"0 0 1 jan,jul *", time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC), false},
+		{"0 0 13 * 5", time.Date(2018, 2, 13, 0, 0, 0, 0, time.UTC), false},
+		{"0 12 * * 7", time.Date(2018, 2, 11, 12, 0, 0, 0, time.UTC), false},
+		{"5/20 * * * *", time.Date(2018, 2, 10, 13, 45, 0, 0, time.UTC), false},
+		{"@hourly", time.Date(2018, 2, 10, 14, 0, 0, 0, time.UTC), false},
+		{"@every 10m", time.Date(2018, 2, 10, 13, 47, 12, 0, time.UTC), false},
+		{"90s", time.Date(2018, 2, 10, 13, 38, 42, 0, time.UTC), false},
+		{"0 0 30 2 *", time.Time{}, false},
+		{"* * * *", time.Time{}, true},
+		{"60 * * * *", time.Time{}, true},
+		{"*/0 * * * *", time.Time{}, true},
+		{"5-1 * * * *", time.Time{}, true},
+		{"@every -1m", time.Time{}, true},
+		{"@often", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Schedule.Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testHandler counts its runs, each taking duration
+type testHandler struct {
+	m        sync.Mutex
+	runs     int
+	duration time.Duration
+	err      error
+}
+
+func (h *testHandler) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	h.m.Lock()
+	h.runs++
+	h.m.Unlock()
+	time.Sleep(h.duration)
+	erc <- h.err
+}
+
+func (h *testHandler) Stop() {}
+
+func waitStatus(t *testing.T, s *Scheduler, dir model.Directory, ok func(Status) bool) Status {
+	var status Status
+	for i := 0; i < 200; i++ {
+		status, _ = s.Status(dir)
+		if ok(status) {
+			return status
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatalf("unexpected status %+v", status)
+	return status
+}
+
+func TestScheduler_Run(t *testing.T) {
+	logger := &log.Logger{Logger: zap.NewNop()}
+	h := &testHandler{err: errors.New("testError")}
+	s := NewScheduler(logger, h, Every(time.Millisecond*10))
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Status("dir"); ok {
+		t.Errorf("Status() of unknown dir ok")
+	}
+
+	erc := make(chan error, 1)
+	go s.Run(context.Background(), "dir", erc)
+	status := waitStatus(t, s, "dir", func(s Status) bool { return s.Runs >= 3 && !s.Running })
+	s.Stop()
+	s.Stop()
+
+	if status.LastErr == nil || status.LastStart.IsZero() || status.LastEnd.IsZero() || status.Next.IsZero() {
+		t.Errorf("Status() = %+v, want last run and next run", status)
+	}
+	select {
+	case err := <-erc:
+		t.Errorf("Scheduler.Run() sent %v, run errors must not end the scheduler", err)
+	default:
+	}
+}
+
+func TestScheduler_Skip(t *testing.T) {
+	logger := &log.Logger{Logger: zap.NewNop()}
+	h := &testHandler{duration: time.Millisecond * 100}
+	s := NewScheduler(logger, h, Every(time.Hour)).WithSchedule("slow", Every(time.Millisecond*10))
+	defer s.Stop()
+
+	go s.Run(context.Background(), "slow", make(chan error))
+	go s.Run(context.Background(), "idle", make(chan error))
+	status := waitStatus(t, s, "slow", func(s Status) bool { return s.Skipped >= 2 })
+	if status.Runs != 1 || !status.Running {
+		t.Errorf("Status() = %+v, want a single running run", status)
+	}
+	idle, _ := s.Status("idle")
+	if idle.Runs != 0 || idle.Next.Sub(time.Now()) < time.Minute {
+		t.Errorf("Status() of idle dir = %+v, want default schedule", idle)
+	}
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	logger := &log.Logger{Logger: zap.NewNop()}
+	s := NewScheduler(logger, &testHandler{}, Every(time.Hour))
+	defer s.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		s.Run(ctx, "dir", make(chan error))
+		close(returned)
+	}()
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Errorf("Scheduler.Run() did not return once ctx was done")
+	}
+}
@@ -0,0 +1,225 @@
+package fsstate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fsstateTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for i, result := range []string{ResultFailed, ResultOK} {
+		if err := s.Put(Entry{Path: "./a.log", Size: int64(i), Result: result}); err != nil {
+			t.Fatalf("Store.Put() error = %v", err)
+		}
+	}
+	if err := s.Put(Entry{Path: "b.log", Result: ResultOK}); err != nil {
+		t.Fatalf("Store.Put() error = %v", err)
+	}
+	// no close, simulating an interrupted run with a partially written line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"path":"c.lo`)
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() after interruption error = %v", err)
+	}
+	defer s.Close()
+	if s.Len() != 2 {
+		t.Errorf("Store.Len() = %d, want 2", s.Len())
+	}
+	e, ok := s.Get("a.log")
+	if !ok || e.Result != ResultOK || e.Size != 1 || e.Updated.IsZero() {
+		t.Errorf("Store.Get() = %+v, %v", e, ok)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("store not compacted, got %d lines", lines)
+	}
+}
+
+func TestStore_TornLine(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := s.Put(Entry{Path: "a.log", Result: ResultOK}); err != nil {
+		t.Fatalf("Store.Put() error = %v", err)
+	}
+	s.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"path":"b.lo`)
+	f.Close()
+
+	// the line put after the torn one must not be joined with it
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() after interruption error = %v", err)
+	}
+	if err := s.Put(Entry{Path: "c.log", Result: ResultOK}); err != nil {
+		t.Fatalf("Store.Put() error = %v", err)
+	}
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+	for _, name := range []string{"a.log", "c.log"} {
+		if _, ok := s.Get(name); !ok {
+			t.Errorf("Store.Get(%q) missing", name)
+		}
+	}
+	if s.Len() != 2 {
+		t.Errorf("Store.Len() = %d, want 2", s.Len())
+	}
+}
+
+// counter is an action counting its calls, optionally rewriting or failing
+type counter struct {
+	calls   map[string]int
+	rewrite bool
+	err     error
+}
+
+func (c *counter) action(ctx context.Context, path string, file os.FileInfo) error {
+	if file.IsDir() {
+		return nil
+	}
+	c.calls[path]++
+	if c.rewrite {
+		return ioutil.WriteFile(path, []byte("scrubbed"), 0644)
+	}
+	return c.err
+}
+
+func TestIncremental(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store, err := Open(filepath.Join(dir, "state.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	data := filepath.Join(dir, "data")
+	if err := os.Mkdir(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(data, "a.log"), filepath.Join(data, "b.log")
+	for _, name := range []string{a, b} {
+		if err := ioutil.WriteFile(name, []byte("1.2.3.4"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := &log.Logger{Logger: zap.NewNop()}
+	c := &counter{calls: make(map[string]int), rewrite: true}
+	crawl := func(version string) *Incremental {
+		i := NewIncremental(logger, store, version, c.action)
+		err := vfs.Walk(vfs.OS{}, data, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// keep crawling on action errors
+			i.Handle(context.Background(), path, info)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("crawl error = %v", err)
+		}
+		return i
+	}
+
+	crawl("v1")
+	if c.calls[a] != 1 || c.calls[b] != 1 {
+		t.Fatalf("first crawl calls = %v", c.calls)
+	}
+
+	// unchanged, even after its own rewrite
+	c.rewrite = false
+	if i := crawl("v1"); c.calls[a] != 1 || c.calls[b] != 1 || i.Skipped() != 2 {
+		t.Errorf("unchanged crawl calls = %v, skipped %d", c.calls, i.Skipped())
+	}
+
+	// touched files with the same content are skipped, changed ones processed
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(a, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(b, []byte("5.6.7.8!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	crawl("v1")
+	if c.calls[a] != 1 || c.calls[b] != 2 {
+		t.Errorf("changed crawl calls = %v", c.calls)
+	}
+
+	// a new pattern set version processes everything again, failures are retried
+	c.err = errors.New("testError")
+	crawl("v2")
+	if c.calls[a] != 2 {
+		t.Errorf("new version crawl calls = %v", c.calls)
+	}
+	if e, _ := store.Get(a); e.Result != ResultFailed || e.Error != "testError" {
+		t.Errorf("failed entry = %+v", e)
+	}
+	c.err = nil
+	crawl("v2")
+	if c.calls[a] != 3 {
+		t.Errorf("retry crawl calls = %v", c.calls)
+	}
+
+	// per file versions only process files whose version changed
+	for path, version := range map[string]string{a: "v2", b: "v3"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := NewIncremental(logger, store, "v2", c.action).WithVersionFunc(func(string) string { return version })
+		if err := i.Handle(context.Background(), path, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.calls[a] != 3 || c.calls[b] != 5 {
+		t.Errorf("version func crawl calls = %v", c.calls)
+	}
+}
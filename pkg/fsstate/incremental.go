@@ -0,0 +1,155 @@
+package fsstate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+)
+
+// Incremental runs actions only for files which changed since they were processed last
+// A file is unchanged if size and modification time match its entry, or if they differ but its content hash
+// is still the same (e.g. after being copied or touched). Files processed with another pattern set
+// version or which failed before are always processed again
+type Incremental struct {
+	log     *log.Logger
+	store   *Store
+	version string
+	// versionOf overrides version per file
+	versionOf func(path string) string
+	actions   []model.Action
+	fs        vfs.Filesystem
+
+	m       sync.Mutex
+	skipped int
+}
+
+// NewIncremental running actions for changed files, version identifies the pattern set in use
+func NewIncremental(log *log.Logger, store *Store, version string, actions ...model.Action) *Incremental {
+	return &Incremental{
+		log:     log,
+		store:   store,
+		version: version,
+		actions: actions,
+		fs:      vfs.OS{},
+	}
+}
+
+// WithFilesystem used for hashing files
+func (i *Incremental) WithFilesystem(fs vfs.Filesystem) *Incremental {
+	i.fs = fs
+	return i
+}
+
+// WithVersionFunc identifies the pattern set per file, e.g. when policies apply different patterns to subtrees
+func (i *Incremental) WithVersionFunc(versionOf func(path string) string) *Incremental {
+	i.versionOf = versionOf
+	return i
+}
+
+func (i *Incremental) versionFor(path string) string {
+	if i.versionOf != nil {
+		return i.versionOf(path)
+	}
+	return i.version
+}
+
+// Skipped returns the number of unchanged files skipped
+func (i *Incremental) Skipped() int {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.skipped
+}
+
+// Handle the file if it changed, recording its state afterwards
+func (i *Incremental) Handle(ctx context.Context, path string, file os.FileInfo) error {
+	if file.IsDir() {
+		return i.run(ctx, path, file)
+	}
+	if i.unchanged(path, file) {
+		i.log.Debug("skipping unchanged file", zap.String("file", path))
+		i.m.Lock()
+		i.skipped++
+		i.m.Unlock()
+		return nil
+	}
+
+	err := i.run(ctx, path, file)
+	// cancelled actions left the file as it was, so it gets handled again next time
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	entry := Entry{
+		Path:     path,
+		Patterns: i.versionFor(path),
+		Result:   ResultOK,
+	}
+	if err != nil {
+		entry.Result = ResultFailed
+		entry.Error = err.Error()
+	}
+	// the actions might have rewritten the file
+	if info, serr := i.fs.Stat(path); serr == nil {
+		entry.Size, entry.ModTime = info.Size(), info.ModTime()
+		entry.Hash, _ = i.hash(path)
+	}
+	if perr := i.store.Put(entry); perr != nil {
+		i.log.Error("unable to record state", zap.String("file", path), zap.Error(perr))
+	}
+	return err
+}
+
+func (i *Incremental) run(ctx context.Context, path string, file os.FileInfo) error {
+	for _, a := range i.actions {
+		if err := a(ctx, path, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unchanged reports whether the file is still in the state recorded after processing it successfully
+func (i *Incremental) unchanged(path string, file os.FileInfo) bool {
+	entry, ok := i.store.Get(path)
+	if !ok || entry.Result != ResultOK || entry.Patterns != i.versionFor(path) {
+		return false
+	}
+	if entry.Size == file.Size() && entry.ModTime.Equal(file.ModTime()) {
+		return true
+	}
+	if entry.Size != file.Size() {
+		return false
+	}
+	hash, err := i.hash(path)
+	if err != nil || hash != entry.Hash {
+		return false
+	}
+	// same content, remember the new modification time to avoid hashing it again
+	entry.ModTime = file.ModTime()
+	entry.Updated = time.Time{}
+	if err := i.store.Put(entry); err != nil {
+		i.log.Error("unable to record state", zap.String("file", path), zap.Error(err))
+	}
+	return true
+}
+
+func (i *Incremental) hash(path string) (string, error) {
+	file, err := i.fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
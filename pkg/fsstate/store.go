@@ -0,0 +1,183 @@
+package fsstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// results of processing a file
+const (
+	ResultOK     = "ok"
+	ResultFailed = "failed"
+)
+
+// Entry is the state of a file after it was processed
+type Entry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Hash     string    `json:"hash"`
+	Patterns string    `json:"patterns"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Store keeps the state of all processed files in a local file
+// Entries are appended as JSON lines as soon as they are put, so an interrupted crawl can resume
+// where it stopped. Outdated lines are dropped when the store is opened again
+type Store struct {
+	path string
+
+	m       sync.Mutex
+	entries map[string]Entry
+	file    *os.File
+	w       *bufio.Writer
+}
+
+// Open the store at path, creating it if it does not exist
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+	lines, torn, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	// a torn last line would be joined with the next put one
+	if lines > len(s.entries) || torn {
+		if err := s.compact(); err != nil {
+			return nil, errors.Wrap(err, "compacting state failed")
+		}
+	}
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.w = bufio.NewWriter(s.file)
+	return s, nil
+}
+
+// load all entries, the last line of a path wins
+// Invalid lines are counted but ignored, torn reports a last line without newline
+// left by an interrupted write
+func (s *Store) load() (lines int, torn bool, err error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		lines++
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Path == "" {
+			continue
+		}
+		s.entries[e.Path] = e
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, false, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return lines, false, err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return 0, false, err
+	}
+	return lines, last[0] != '\n', nil
+}
+
+// compact rewrites the store with a single line per path
+func (s *Store) compact() error {
+	tmp, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, path := range s.paths() {
+		if err := enc.Encode(s.entries[path]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *Store) paths() []string {
+	paths := make([]string, 0, len(s.entries))
+	for path := range s.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Get the entry of path
+func (s *Store) Get(path string) (Entry, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	e, ok := s.entries[filepath.Clean(path)]
+	return e, ok
+}
+
+// Put the entry, replacing the previous one of its path
+func (s *Store) Put(e Entry) error {
+	e.Path = filepath.Clean(e.Path)
+	if e.Updated.IsZero() {
+		e.Updated = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.entries[e.Path] = e
+	s.w.Write(data)
+	s.w.WriteByte('\n')
+	return s.w.Flush()
+}
+
+// Len returns the number of files in the store
+func (s *Store) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.entries)
+}
+
+// Close the store
+func (s *Store) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.w.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
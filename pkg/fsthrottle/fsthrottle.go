@@ -0,0 +1,88 @@
+package fsthrottle
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+)
+
+// Filesystem limits the bytes read and written per second on the wrapped Filesystem
+// Limits are shared by everything using it, like crawler and watcher actions running in parallel
+type Filesystem struct {
+	vfs.Filesystem
+	read  *Limiter
+	write *Limiter
+	// ctx interrupts waiting for the limiters once it is done
+	ctx context.Context
+}
+
+// NewFilesystem throttling fs with the limiters, nil limiters do not limit
+func NewFilesystem(fs vfs.Filesystem, read, write *Limiter) *Filesystem {
+	return &Filesystem{
+		Filesystem: fs,
+		read:       read,
+		write:      write,
+		ctx:        context.Background(),
+	}
+}
+
+// WithContext interrupts throttled reads and writes with the error of ctx once it is done
+// Interrupted writes leave the file unchanged, as they fail before writing anything
+func (f *Filesystem) WithContext(ctx context.Context) *Filesystem {
+	f.ctx = ctx
+	return f
+}
+
+// Open the file for reading at the read rate
+func (f *Filesystem) Open(name string) (io.ReadCloser, error) {
+	file, err := f.Filesystem.Open(name)
+	if err != nil || f.read == nil {
+		return file, err
+	}
+	return &reader{ReadCloser: file, limiter: f.read, ctx: f.ctx}, nil
+}
+
+// WriteFile once the write rate allows for data
+func (f *Filesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if f.write != nil {
+		if err := f.write.Wait(f.ctx, len(data)); err != nil {
+			return err
+		}
+	}
+	return f.Filesystem.WriteFile(name, data, perm)
+}
+
+// reader accounts every read for its limiter
+type reader struct {
+	io.ReadCloser
+	limiter *Limiter
+	ctx     context.Context
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if werr := r.limiter.Wait(r.ctx, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// Action runs actions for at most the rate of files allowed by l, dirs are not limited
+func Action(l *Limiter, actions ...model.Action) model.Action {
+	return func(ctx context.Context, path string, file os.FileInfo) error {
+		if !file.IsDir() {
+			if err := l.Wait(ctx, 1); err != nil {
+				return err
+			}
+		}
+		for _, a := range actions {
+			if err := a(ctx, path, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
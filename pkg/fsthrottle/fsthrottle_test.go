@@ -0,0 +1,150 @@
+package fsthrottle
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		waits []int
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"unlimited", 0, 0, []int{1 << 20, 1 << 20}, 0, 10 * time.Millisecond},
+		{"burst", 100, 10, []int{5, 5}, 0, 10 * time.Millisecond},
+		{"beyond burst", 100, 10, []int{10, 5}, 40 * time.Millisecond, 100 * time.Millisecond},
+		{"larger than burst", 100, 10, []int{20}, 90 * time.Millisecond, 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rate, tt.burst)
+			start := time.Now()
+			for _, n := range tt.waits {
+				l.WaitN(n)
+			}
+			if d := time.Since(start); d < tt.min || d > tt.max {
+				t.Errorf("WaitN() took %v, want %v to %v", d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestFilesystem(t *testing.T) {
+	mem := vfs.NewMem()
+	mem.WriteFile("a.log", make([]byte, 100), 0644)
+	fs := NewFilesystem(mem, NewLimiter(1000, 50), NewLimiter(1000, 50))
+
+	start := time.Now()
+	f, err := fs.Open("a.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || len(data) != 100 {
+		t.Fatalf("ReadAll() = %d, %v", len(data), err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("reading 100 bytes at 1000/s with a burst of 50 took %v", d)
+	}
+
+	start = time.Now()
+	if err := fs.WriteFile("b.log", make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("writing 100 bytes at 1000/s with a burst of 50 took %v", d)
+	}
+	if _, err := fs.Stat("b.log"); err != nil {
+		t.Errorf("Stat() = %v", err)
+	}
+}
+
+func TestFilesystem_Cancel(t *testing.T) {
+	mem := vfs.NewMem()
+	mem.WriteFile("a.log", make([]byte, 100), 0644)
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := NewFilesystem(mem, NewLimiter(10, 1), NewLimiter(10, 1)).WithContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	// without cancellation both would take about ten seconds
+	start := time.Now()
+	if err := fs.WriteFile("b.log", make([]byte, 100), 0644); err != context.Canceled {
+		t.Errorf("WriteFile() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := mem.Stat("b.log"); !os.IsNotExist(err) {
+		t.Errorf("WriteFile() wrote the file after being cancelled")
+	}
+	f, err := fs.Open("a.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := ioutil.ReadAll(f); err != context.Canceled {
+		t.Errorf("ReadAll() error = %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cancelled throttling took %v", d)
+	}
+}
+
+func TestAction(t *testing.T) {
+	mem := vfs.NewMem()
+	mem.WriteFile("a.log", nil, 0644)
+	file, _ := mem.Lstat("a.log")
+	dir, _ := mem.Lstat(".")
+
+	calls := 0
+	action := Action(NewLimiter(100, 1), func(ctx context.Context, path string, file os.FileInfo) error {
+		calls++
+		return nil
+	})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		action(context.Background(), "a.log", file)
+	}
+	for i := 0; i < 10; i++ {
+		action(context.Background(), ".", dir)
+	}
+	if d := time.Since(start); d < 15*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("3 files at 100/s took %v", d)
+	}
+	if calls != 13 {
+		t.Errorf("actions called %d times, want 13", calls)
+	}
+}
+
+func TestParseIOPriority(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    IOPriority
+		wantErr bool
+	}{
+		{"", IOPriority{}, false},
+		{"idle", IOPriority{Class: IOClassIdle}, false},
+		{"best-effort", IOPriority{Class: IOClassBestEffort, Level: 4}, false},
+		{"best-effort:7", IOPriority{Class: IOClassBestEffort, Level: 7}, false},
+		{"realtime:0", IOPriority{Class: IOClassRealtime}, false},
+		{"best-effort:8", IOPriority{}, true},
+		{"low", IOPriority{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseIOPriority(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseIOPriority(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+			continue
+		}
+		if !tt.wantErr && tt.in != "" && tt.in != "best-effort" && got.String() != tt.in {
+			t.Errorf("String() = %q, want %q", got.String(), tt.in)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package fsthrottle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IOClass is the I/O scheduling class of the process
+type IOClass int
+
+// I/O scheduling classes as defined by linux
+const (
+	IOClassNone IOClass = iota
+	IOClassRealtime
+	IOClassBestEffort
+	IOClassIdle
+)
+
+// IOPriority is the I/O scheduling class and level, 0 being the highest and 7 the lowest level
+type IOPriority struct {
+	Class IOClass
+	Level int
+}
+
+// ParseIOPriority from its flag representation: idle, best-effort[:level] or realtime[:level]
+func ParseIOPriority(s string) (IOPriority, error) {
+	name, level := s, "4"
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, level = s[:i], s[i+1:]
+	}
+	var p IOPriority
+	switch name {
+	case "", "none":
+		return IOPriority{}, nil
+	case "idle":
+		p.Class = IOClassIdle
+		level = "0"
+	case "best-effort":
+		p.Class = IOClassBestEffort
+	case "realtime":
+		p.Class = IOClassRealtime
+	default:
+		return IOPriority{}, fmt.Errorf("unsupported io priority class %q", name)
+	}
+	l, err := strconv.Atoi(level)
+	if err != nil || l < 0 || l > 7 {
+		return IOPriority{}, fmt.Errorf("invalid io priority level %q, must be 0-7", level)
+	}
+	p.Level = l
+	return p, nil
+}
+
+func (p IOPriority) String() string {
+	switch p.Class {
+	case IOClassIdle:
+		return "idle"
+	case IOClassBestEffort:
+		return fmt.Sprintf("best-effort:%d", p.Level)
+	case IOClassRealtime:
+		return fmt.Sprintf("realtime:%d", p.Level)
+	}
+	return "none"
+}
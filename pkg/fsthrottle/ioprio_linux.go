@@ -0,0 +1,40 @@
+package fsthrottle
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// SetIOPriority of the running process
+// Linux sets the priority per thread, so it is applied to all threads of the process.
+// Threads started later inherit it from the thread starting them
+func SetIOPriority(p IOPriority) error {
+	if p.Class == IOClassNone {
+		return nil
+	}
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return errors.Wrap(err, "listing threads failed")
+	}
+	prio := uintptr(int(p.Class)<<ioprioClassShift | p.Level)
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio)
+		// threads might have exited in the meantime
+		if errno != 0 && errno != syscall.ESRCH {
+			return errors.Wrapf(errno, "setting io priority %s failed", p)
+		}
+	}
+	return nil
+}
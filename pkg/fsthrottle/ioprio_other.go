@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package fsthrottle
+
+import "errors"
+
+// SetIOPriority is only supported on linux
+func SetIOPriority(p IOPriority) error {
+	if p.Class == IOClassNone {
+		return nil
+	}
+	return errors.New("io priorities are only supported on linux")
+}
@@ -0,0 +1,81 @@
+package fsthrottle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket limiting a rate shared by all its users
+// Requests larger than the available tokens are granted right away but delayed by the debt they leave,
+// so the average rate holds even for requests larger than the burst
+// A nil Limiter does not limit anything
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	m      sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter allowing rate tokens per second with bursts of up to burst tokens
+// It returns nil, which is unlimited, if rate is not positive
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n tokens are available
+func (l *Limiter) WaitN(n int) {
+	if wait := l.reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Wait blocks until n tokens are available or ctx is done
+// Tokens reserved are not returned if ctx is done before
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	wait := l.reserve(n)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve n tokens, returning how long to wait for them
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil || n <= 0 {
+		return 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
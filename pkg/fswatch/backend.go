@@ -0,0 +1,31 @@
+package fswatch
+
+import "fmt"
+
+// Backend defines how file changes are captured while watching
+type Backend int
+
+const (
+	// BackendFsnotify watches every dir with inotify or its platform equivalent
+	BackendFsnotify Backend = iota
+	// BackendFanotify marks whole file systems with fanotify, falling back to fsnotify where it is not available
+	BackendFanotify
+)
+
+// ParseBackend from its flag representation
+func ParseBackend(s string) (Backend, error) {
+	switch s {
+	case "fsnotify", "":
+		return BackendFsnotify, nil
+	case "fanotify":
+		return BackendFanotify, nil
+	}
+	return BackendFsnotify, fmt.Errorf("unsupported watch backend %q", s)
+}
+
+func (b Backend) String() string {
+	if b == BackendFanotify {
+		return "fanotify"
+	}
+	return "fsnotify"
+}
@@ -0,0 +1,421 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/playnet-public/libs/log"
+
+	"github.com/playnet-public/fscrub/pkg/fsignore"
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"go.uber.org/zap"
+)
+
+// notifier reports files closed after writing anywhere on the file systems it marked
+type notifier interface {
+	// mark the file system of dev containing path
+	mark(dev uint64, path string) error
+	// unmark the file system of dev
+	unmark(dev uint64) error
+	// read blocks until events arrive or the notifier is closed
+	read() ([]notifyEvent, error)
+	close() error
+}
+
+// notifyEvent is a file closed after writing, or an overflow of the event queue
+type notifyEvent struct {
+	path     string
+	pid      int
+	overflow bool
+}
+
+// fanotifyRoot is a dir the Fanotify watcher runs for
+type fanotifyRoot struct {
+	// dir as passed to Run, handled paths are relative to it
+	dir string
+	// abs is the absolute dir events are matched against
+	abs string
+	dev uint64
+}
+
+// Fanotify is a handler watching dirs by marking the whole file system containing them with fanotify,
+// so its kernel resources do not grow with the number of dirs like the inotify watches of the Watcher do.
+// Files are handled once they got closed after writing, so no quiet period is needed.
+// Fanotify requires linux and CAP_SYS_ADMIN, dirs it is not available for are passed to the fallback
+type Fanotify struct {
+	log      *log.Logger
+	actions  []model.Action
+	fs       vfs.Filesystem
+	fallback model.Handler
+	// sync handles all files of a dir once it is marked
+	sync bool
+
+	// notify is nil if fanotify is not available, err tells why
+	notify notifier
+	err    error
+
+	// ctx is passed to the actions handling events, it is cancelled by Stop
+	ctx      context.Context
+	cancel   context.CancelFunc
+	loop     chan struct{}
+	runs     sync.WaitGroup
+	stopOnce sync.Once
+
+	m     sync.Mutex
+	roots []fanotifyRoot
+	ready map[string]bool
+	// ignore is nil if ignore files are not honored
+	ignore  *fsignore.Matcher
+	handled *handledFiles
+	// policies are invalidated whenever policy files are written
+	policies *fspolicy.Resolver
+}
+
+// NewFanotify with logger
+// If fanotify is not available, all dirs are passed to the fallback set by WithFallback
+func NewFanotify(log *log.Logger, actions ...model.Action) *Fanotify {
+	notify, err := openNotifier()
+	return newFanotify(log, notify, err, actions...)
+}
+
+func newFanotify(log *log.Logger, notify notifier, err error, actions ...model.Action) *Fanotify {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Fanotify{
+		log:     log,
+		actions: actions,
+		fs:      vfs.OS{},
+		ctx:     ctx,
+		cancel:  cancel,
+		loop:    make(chan struct{}),
+		ready:   make(map[string]bool),
+		ignore:  fsignore.NewMatcher(log, vfs.OS{}),
+		handled: newHandledFiles(maxHandled),
+		notify:  notify,
+		err:     err,
+	}
+	if err != nil {
+		f.notify = nil
+		log.Warn("fanotify not available", zap.Error(err))
+		close(f.loop)
+		return f
+	}
+	go f.watch()
+	return f
+}
+
+// WithFilesystem used for accessing the files reported by fanotify
+// Events are always captured from the os, so fs has to be backed by it
+func (f *Fanotify) WithFilesystem(fs vfs.Filesystem) *Fanotify {
+	f.fs = fs
+	if f.ignore != nil {
+		f.ignore = fsignore.NewMatcher(f.log, fs)
+	}
+	return f
+}
+
+// WithIgnoreFiles sets whether the ignore files found in the dirs are honored, which they are by default
+func (f *Fanotify) WithIgnoreFiles(enabled bool) *Fanotify {
+	f.ignore = nil
+	if enabled {
+		f.ignore = fsignore.NewMatcher(f.log, f.fs)
+	}
+	return f
+}
+
+// WithPolicies invalidates the policy files cached by r when they are written
+// fanotify does not report removed files, so removed policy files are only noticed by the fallback
+func (f *Fanotify) WithPolicies(r *fspolicy.Resolver) *Fanotify {
+	f.policies = r
+	return f
+}
+
+// WithFallback handles the dirs fanotify is not available for, e.g. because of missing capabilities
+func (f *Fanotify) WithFallback(h model.Handler) *Fanotify {
+	f.fallback = h
+	return f
+}
+
+// WithInitialSync handles all files of a dir once it is marked, covering changes from before fscrub started
+func (f *Fanotify) WithInitialSync(sync bool) *Fanotify {
+	f.sync = sync
+	return f
+}
+
+// Available reports whether fanotify could be initialized, otherwise all dirs are passed to the fallback
+func (f *Fanotify) Available() bool {
+	return f.notify != nil
+}
+
+// Run the watcher for dir and everything below it until the watcher is stopped or ctx is done
+func (f *Fanotify) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	if f.notify == nil {
+		f.runFallback(ctx, dir, erc, f.err)
+		return
+	}
+	root, err := f.root(dir)
+	if err != nil {
+		erc <- err
+		return
+	}
+	f.m.Lock()
+	if f.ctx.Err() != nil {
+		f.m.Unlock()
+		return
+	}
+	if err := f.notify.mark(root.dev, root.abs); err != nil {
+		f.m.Unlock()
+		f.runFallback(ctx, dir, erc, err)
+		return
+	}
+	f.roots = append(f.roots, root)
+	f.runs.Add(1)
+	f.m.Unlock()
+	defer f.runs.Done()
+	defer f.release(root)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-f.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	f.log.Info("start handling", zap.String("dir", dir.String()), zap.String("handler", "fanotify"))
+	defer f.log.Info("stop handling", zap.String("dir", dir.String()), zap.String("handler", "fanotify"))
+	if f.sync {
+		f.log.Info("starting initial sync", zap.String("dir", dir.String()))
+		err = vfs.Walk(f.fs, root.dir, func(path string, file os.FileInfo, err error) error {
+			return f.syncFile(ctx, root, path, file, err)
+		})
+		if err != nil && ctx.Err() != nil {
+			f.log.Info("initial sync cancelled", zap.String("dir", dir.String()))
+			return
+		}
+		if err != nil {
+			erc <- err
+			return
+		}
+		f.log.Info("initial sync finished", zap.String("dir", dir.String()))
+	}
+	f.m.Lock()
+	f.ready[root.dir] = true
+	f.m.Unlock()
+	<-ctx.Done()
+}
+
+// runFallback passes dir to the fallback handler because fanotify is not available for it
+func (f *Fanotify) runFallback(ctx context.Context, dir model.Directory, erc chan error, err error) {
+	if f.fallback == nil {
+		erc <- err
+		return
+	}
+	f.log.Warn("fanotify not available for dir, falling back", zap.String("dir", dir.String()), zap.Error(err))
+	f.fallback.Run(ctx, dir, erc)
+}
+
+func (f *Fanotify) root(dir model.Directory) (fanotifyRoot, error) {
+	root := fanotifyRoot{dir: filepath.Clean(dir.String())}
+	abs, err := filepath.Abs(root.dir)
+	if err != nil {
+		return root, err
+	}
+	// events report the resolved path of a file
+	if root.abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return root, err
+	}
+	info, err := f.fs.Stat(root.dir)
+	if err != nil {
+		return root, err
+	}
+	id, _ := identify(info)
+	root.dev = id.dev
+	return root, nil
+}
+
+// Ready reports whether dir is marked and its initial sync finished
+func (f *Fanotify) Ready(dir model.Directory) bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.ready[filepath.Clean(dir.String())]
+}
+
+// release root once its run ended, unmarking its file system if no other root is on it
+func (f *Fanotify) release(root fanotifyRoot) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for i, r := range f.roots {
+		if r == root {
+			f.roots = append(f.roots[:i], f.roots[i+1:]...)
+			break
+		}
+	}
+	delete(f.ready, root.dir)
+	for _, r := range f.roots {
+		if r.dev == root.dev {
+			return
+		}
+	}
+	if err := f.notify.unmark(root.dev); err != nil {
+		f.log.Error("unable to unmark file system", zap.String("dir", root.dir), zap.Error(err))
+	}
+}
+
+func (f *Fanotify) watch() {
+	defer close(f.loop)
+	for {
+		events, err := f.notify.read()
+		if f.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			f.log.Error("error in fanotify", zap.Error(err))
+			continue
+		}
+		for _, event := range events {
+			f.event(event)
+		}
+	}
+}
+
+// event handles a file closed after writing if it is below any root
+func (f *Fanotify) event(event notifyEvent) {
+	if event.overflow {
+		f.log.Warn("fanotify event queue overflowed, changes may have been missed")
+		return
+	}
+	root, path, ok := f.resolve(event.path)
+	if !ok || vfs.IsTempFile(path) {
+		return
+	}
+	// the file got written by fscrub itself
+	if event.pid == os.Getpid() {
+		f.log.Debug("ignoring event caused by own write", zap.String("file", path))
+		return
+	}
+	f.log.Debug("file event captured", zap.String("file", path), zap.Int("pid", event.pid))
+	if f.ignore != nil && fsignore.IsIgnoreFile(path) {
+		f.log.Info("reloading ignore file", zap.String("file", path))
+		f.ignore.Invalidate(filepath.Dir(path))
+	}
+	if fspolicy.IsPolicyFile(path) {
+		reloadPolicy(f.log, f.policies, path)
+	}
+	if f.ignore.Ignored(root, path, false) {
+		f.log.Debug("skipping ignored path", zap.String("path", path))
+		return
+	}
+	file, err := f.fs.Lstat(path)
+	if err != nil {
+		f.m.Lock()
+		f.handled.remove(path)
+		f.m.Unlock()
+		return
+	}
+	if !file.Mode().IsRegular() {
+		return
+	}
+	f.handle(f.ctx, path, file, "closed")
+}
+
+// resolve the absolute path of an event to the dir of the innermost root containing it
+func (f *Fanotify) resolve(abs string) (string, string, bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	var root fanotifyRoot
+	for _, r := range f.roots {
+		if primitives.Within(abs, r.abs) && len(r.abs) > len(root.abs) {
+			root = r
+		}
+	}
+	if root.abs == "" {
+		return "", "", false
+	}
+	rel, err := filepath.Rel(root.abs, abs)
+	if err != nil {
+		return "", "", false
+	}
+	return root.dir, filepath.Join(root.dir, rel), true
+}
+
+// syncFile handles a file found by the initial sync
+// Failing files are logged and skipped so the watcher keeps running
+func (f *Fanotify) syncFile(ctx context.Context, root fanotifyRoot, path string, file os.FileInfo, err error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err != nil {
+		f.log.Error("unable to sync path", zap.String("path", path), zap.Error(err))
+		if file != nil && file.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if path != root.dir && (vfs.IsTempFile(path) || f.ignore.Ignored(root.dir, path, file.IsDir())) {
+		f.log.Debug("skipping ignored path", zap.String("path", path))
+		if file.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if !file.Mode().IsRegular() {
+		return nil
+	}
+	f.handle(ctx, path, file, "synced")
+	return nil
+}
+
+// handle a file unless it looks exactly like after it was handled last, remembering its state afterwards
+func (f *Fanotify) handle(ctx context.Context, path string, file os.FileInfo, event string) {
+	f.m.Lock()
+	last, seen := f.handled.get(path)
+	f.m.Unlock()
+	if seen && last == stateOf(file) {
+		f.log.Debug("skipping file already handled", zap.String("file", path))
+		return
+	}
+	f.log.Info("handling file event", zap.String("type", event), zap.String("file", path))
+	for _, a := range f.actions {
+		if err := a(ctx, path, file); err != nil {
+			f.log.Error("failed handling file event",
+				zap.String("type", event),
+				zap.String("file", path),
+				zap.Error(err),
+			)
+			return
+		}
+	}
+	file, err := f.fs.Lstat(path)
+	f.m.Lock()
+	defer f.m.Unlock()
+	if err != nil {
+		f.handled.remove(path)
+		return
+	}
+	f.handled.put(path, stateOf(file))
+}
+
+// Stop watching all dirs and stop the fallback, waiting for the files in progress
+func (f *Fanotify) Stop() {
+	f.stopOnce.Do(func() {
+		f.log.Info("stopping fanotify watcher")
+		f.m.Lock()
+		f.cancel()
+		f.m.Unlock()
+		f.runs.Wait()
+		if f.notify != nil {
+			f.notify.close()
+		}
+		<-f.loop
+		if f.fallback != nil {
+			f.fallback.Stop()
+		}
+		f.log.Info("fanotify watcher stopped")
+	})
+}
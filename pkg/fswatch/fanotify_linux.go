@@ -0,0 +1,185 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package fswatch
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	fanClassNotif = 0x0
+	fanCloexec    = 0x1
+	fanNonblock   = 0x2
+
+	fanMarkAdd        = 0x1
+	fanMarkRemove     = 0x2
+	fanMarkMount      = 0x10
+	fanMarkFilesystem = 0x100
+
+	fanCloseWrite = 0x8
+	fanQOverflow  = 0x4000
+
+	fanMetadataVersion = 3
+	fanMetadataLen     = 24
+
+	atFDCWD = -100
+)
+
+// fanotifyNotifier reads the events of a fanotify group
+// Its fd is non-blocking, so reads wait in the runtime poller and get interrupted by closing it
+type fanotifyNotifier struct {
+	file *os.File
+	buf  []byte
+
+	m sync.Mutex
+	// marks set for each device
+	marks map[uint64]*fanotifyMark
+}
+
+// fanotifyMark of a file system, mount marks only cover the mounts of the paths marked
+type fanotifyMark struct {
+	kind  uintptr
+	paths []string
+}
+
+// openNotifier initializes a fanotify group, which requires CAP_SYS_ADMIN
+func openNotifier() (notifier, error) {
+	fd, _, errno := syscall.Syscall(syscall.SYS_FANOTIFY_INIT,
+		fanClassNotif|fanCloexec|fanNonblock,
+		uintptr(os.O_RDONLY|syscall.O_LARGEFILE|syscall.O_CLOEXEC),
+		0,
+	)
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "fanotify_init failed")
+	}
+	return &fanotifyNotifier{
+		file:  os.NewFile(fd, "fanotify"),
+		buf:   make([]byte, 4096*fanMetadataLen),
+		marks: make(map[uint64]*fanotifyMark),
+	}, nil
+}
+
+// mark the file system of dev containing path, or the mount of path on kernels older than 4.20
+func (n *fanotifyNotifier) mark(dev uint64, path string) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+	kinds := []uintptr{fanMarkFilesystem, fanMarkMount}
+	if m, ok := n.marks[dev]; ok {
+		if m.kind == fanMarkFilesystem {
+			return nil
+		}
+		kinds = []uintptr{m.kind}
+	}
+	var err error
+	for _, kind := range kinds {
+		err = n.fanotifyMark(fanMarkAdd|kind, path)
+		if err == nil {
+			m, ok := n.marks[dev]
+			if !ok {
+				m = &fanotifyMark{kind: kind}
+				n.marks[dev] = m
+			}
+			m.paths = append(m.paths, path)
+			return nil
+		}
+		if err != syscall.EINVAL {
+			break
+		}
+	}
+	return errors.Wrapf(err, "fanotify_mark of %s failed", path)
+}
+
+// unmark the file system of dev
+func (n *fanotifyNotifier) unmark(dev uint64) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+	m, ok := n.marks[dev]
+	if !ok {
+		return nil
+	}
+	delete(n.marks, dev)
+	for _, path := range m.paths {
+		if err := n.fanotifyMark(fanMarkRemove|m.kind, path); err != nil {
+			return errors.Wrapf(err, "fanotify_mark of %s failed", path)
+		}
+		// a file system mark is gone once removed through any path
+		if m.kind == fanMarkFilesystem {
+			break
+		}
+	}
+	return nil
+}
+
+func (n *fanotifyNotifier) fanotifyMark(flags uintptr, path string) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	conn, err := n.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	dirfd := atFDCWD
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_FANOTIFY_MARK, fd, flags, fanCloseWrite, uintptr(dirfd), uintptr(unsafe.Pointer(p)), 0)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// read the next events, resolving the fd of each to its path and closing it
+func (n *fanotifyNotifier) read() ([]notifyEvent, error) {
+	l, err := n.file.Read(n.buf)
+	if err != nil {
+		return nil, err
+	}
+	var events []notifyEvent
+	for b := n.buf[:l]; len(b) >= fanMetadataLen; {
+		eventLen := binary.LittleEndian.Uint32(b[0:4])
+		if b[4] != fanMetadataVersion {
+			return events, errors.Errorf("unsupported fanotify metadata version %d", b[4])
+		}
+		if eventLen < fanMetadataLen || int(eventLen) > len(b) {
+			return events, errors.Errorf("invalid fanotify event length %d", eventLen)
+		}
+		mask := binary.LittleEndian.Uint64(b[8:16])
+		fd := int(int32(binary.LittleEndian.Uint32(b[16:20])))
+		pid := int(int32(binary.LittleEndian.Uint32(b[20:24])))
+		b = b[eventLen:]
+
+		if mask&fanQOverflow != 0 {
+			events = append(events, notifyEvent{overflow: true})
+		}
+		if fd < 0 {
+			continue
+		}
+		path, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+		syscall.Close(fd)
+		// the file got removed since
+		if err != nil || strings.HasSuffix(path, " (deleted)") {
+			continue
+		}
+		events = append(events, notifyEvent{path: path, pid: pid})
+	}
+	return events, nil
+}
+
+func (n *fanotifyNotifier) close() error {
+	return n.file.Close()
+}
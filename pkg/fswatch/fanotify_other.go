@@ -0,0 +1,11 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package fswatch
+
+import "errors"
+
+// openNotifier fails as fanotify is only supported on linux
+func openNotifier() (notifier, error) {
+	return nil, errors.New("fanotify is not supported on this platform")
+}
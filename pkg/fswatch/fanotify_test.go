@@ -0,0 +1,240 @@
+package fswatch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/libs/log"
+)
+
+// fallbackHandler records the dirs it runs for
+type fallbackHandler struct {
+	dirs  chan model.Directory
+	stops int
+}
+
+func (h *fallbackHandler) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	h.dirs <- dir
+}
+
+func (h *fallbackHandler) Stop() {
+	h.stops++
+}
+
+// writeExternal writes path from another process, as events caused by fscrub itself are ignored
+func writeExternal(t *testing.T, path, data string) {
+	if out, err := exec.Command("sh", "-c", `printf %s "$1" > "$2"`, "sh", data, path).CombinedOutput(); err != nil {
+		t.Fatalf("writing %s failed: %v %s", path, err, out)
+	}
+}
+
+func TestFanotify(t *testing.T) {
+	r := &recorder{paths: make(map[string]int)}
+	// rewrite logs like fscrub does, which must not trigger another handling
+	rewrite := func(ctx context.Context, path string, file os.FileInfo) error {
+		r.action(ctx, path, file)
+		if filepath.Ext(path) != ".log" {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte("scrubbed"), 0644)
+	}
+	f := NewFanotify(log.NewNop(), rewrite).WithInitialSync(true)
+	defer f.Stop()
+	if !f.Available() {
+		t.Skipf("fanotify not available: %v", f.err)
+	}
+
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".fscrubignore"), []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(dir, "existing.log")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	erc := make(chan error, 1)
+	go f.Run(context.Background(), model.Directory(dir), erc)
+	for i := 0; i < 200 && !f.Ready(model.Directory(dir)); i++ {
+		select {
+		case err := <-erc:
+			t.Fatalf("Fanotify.Run() error = %v", err)
+		case <-time.After(time.Millisecond * 5):
+		}
+	}
+	if n := r.count(existing); n != 1 {
+		t.Fatalf("initial sync handled %s %d times, want 1", existing, n)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(dir, "sub", "created.log")
+	paths := []string{created, filepath.Join(dir, "ignored.tmp"), filepath.Join(outside, "outside.log"), existing}
+	for _, path := range paths {
+		writeExternal(t, path, "changed")
+	}
+	r.wait(t, created)
+	for i := 0; i < 100 && r.count(existing) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	want := map[string]int{created: 1, paths[1]: 0, paths[2]: 0, existing: 2}
+	for path, n := range want {
+		if got := r.count(path); got != n {
+			t.Errorf("%s handled %d times, want %d", path, got, n)
+		}
+	}
+}
+
+// unmarkable is a notifier failing to mark any file system
+type unmarkable struct {
+	closed chan struct{}
+}
+
+func (n *unmarkable) mark(dev uint64, path string) error {
+	return errors.New("operation not supported")
+}
+
+func (n *unmarkable) unmark(dev uint64) error {
+	return nil
+}
+
+func (n *unmarkable) read() ([]notifyEvent, error) {
+	<-n.closed
+	return nil, os.ErrClosed
+}
+
+func (n *unmarkable) close() error {
+	close(n.closed)
+	return nil
+}
+
+func TestFanotify_Fallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		notify   notifier
+		err      error
+		fallback bool
+	}{
+		{"missingCapabilities", nil, errors.New("operation not permitted"), true},
+		{"unmarkable", &unmarkable{closed: make(chan struct{})}, nil, true},
+		{"noFallback", nil, errors.New("operation not permitted"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := &fallbackHandler{dirs: make(chan model.Directory, 1)}
+			f := newFanotify(log.NewNop(), tt.notify, tt.err)
+			if tt.fallback {
+				f.WithFallback(fallback)
+			}
+			erc := make(chan error, 1)
+			f.Run(context.Background(), model.Directory(os.TempDir()), erc)
+			f.Stop()
+
+			select {
+			case dir := <-fallback.dirs:
+				if !tt.fallback || dir != model.Directory(os.TempDir()) {
+					t.Errorf("fallback ran for %s", dir)
+				}
+			case err := <-erc:
+				if tt.fallback {
+					t.Errorf("Fanotify.Run() error = %v, want fallback", err)
+				}
+			default:
+				t.Errorf("Fanotify.Run() neither failed nor fell back")
+			}
+			if tt.fallback && fallback.stops != 1 {
+				t.Errorf("fallback stopped %d times, want once", fallback.stops)
+			}
+		})
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	for _, b := range []Backend{BackendFsnotify, BackendFanotify} {
+		got, err := ParseBackend(b.String())
+		if err != nil || got != b {
+			t.Errorf("ParseBackend(%q) = %v, %v", b.String(), got, err)
+		}
+	}
+	if _, err := ParseBackend("kqueue"); err == nil {
+		t.Errorf("ParseBackend() of unknown backend error = nil")
+	}
+}
+
+func TestHandledFiles(t *testing.T) {
+	h := newHandledFiles(2)
+	h.put("a", fileState{size: 1})
+	h.put("b", fileState{size: 2})
+	// reading a keeps it, so b is the least recently used
+	if state, ok := h.get("a"); !ok || state.size != 1 {
+		t.Errorf("get(a) = %v, %v", state, ok)
+	}
+	h.put("c", fileState{size: 3})
+	if _, ok := h.get("b"); ok {
+		t.Errorf("get(b) found evicted file")
+	}
+	h.remove("a")
+	if _, ok := h.get("a"); ok || h.len() != 1 {
+		t.Errorf("handledFiles holds %d files after remove, want 1", h.len())
+	}
+}
+
+func TestFanotify_SharedMark(t *testing.T) {
+	r := &recorder{paths: make(map[string]int)}
+	f := NewFanotify(log.NewNop(), r.action)
+	defer f.Stop()
+	if !f.Available() {
+		t.Skipf("fanotify not available: %v", f.err)
+	}
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "fswatchTests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, dir)
+	}
+
+	// both dirs share the file system mark
+	ctx, cancel := context.WithCancel(context.Background())
+	released := make(chan struct{})
+	go func() {
+		f.Run(ctx, model.Directory(dirs[0]), make(chan error, 1))
+		close(released)
+	}()
+	go f.Run(context.Background(), model.Directory(dirs[1]), make(chan error, 1))
+	for i := 0; i < 200 && !(f.Ready(model.Directory(dirs[0])) && f.Ready(model.Directory(dirs[1]))); i++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	cancel()
+	<-released
+
+	// releasing one dir must not unmark the other
+	kept, dropped := filepath.Join(dirs[1], "a.log"), filepath.Join(dirs[0], "a.log")
+	writeExternal(t, dropped, "a")
+	writeExternal(t, kept, "a")
+	r.wait(t, kept)
+	if n := r.count(dropped); n != 0 {
+		t.Errorf("released dir handled %d times, want 0", n)
+	}
+}
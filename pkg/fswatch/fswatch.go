@@ -1,21 +1,60 @@
 package fswatch
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/playnet-public/libs/log"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/playnet-public/fscrub/pkg/fsignore"
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
 	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
 	"go.uber.org/zap"
 )
 
 // Watcher defines the dir watching handler
 type Watcher struct {
-	log       *log.Logger
-	interrupt chan bool
-	actions   []model.Action
-	watcher   *fsnotify.Watcher
+	log     *log.Logger
+	actions []model.Action
+	watcher *fsnotify.Watcher
+	fs      vfs.Filesystem
+
+	// ctx is passed to the actions handling events, it is cancelled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	// loop is closed once events are no longer handled
+	loop     chan struct{}
+	runs     sync.WaitGroup
+	stopOnce sync.Once
+
+	m       sync.Mutex
+	watched map[string]bool
+	// roots are the dirs the watcher runs for, ignore files apply below them
+	roots []string
+	ready map[string]bool
+	// ignore is nil if ignore files are not honored
+	ignore *fsignore.Matcher
+	// policies are invalidated whenever policy files change
+	policies *fspolicy.Resolver
+
+	// quiet is the time a file has to stay unchanged before it gets handled
+	quiet   time.Duration
+	pending map[string]*pendingFile
+	handled *handledFiles
+
+	// sync enables crawling dirs once their watches are registered
+	sync bool
+	// busy files are handled by the initial sync right now
+	busy    map[string]bool
+	settled chan string
+	done    chan struct{}
 }
 
 // NewWatcher with logger
@@ -26,13 +65,25 @@ func NewWatcher(log *log.Logger, actions ...model.Action) *Watcher {
 		return nil
 	}
 
-	interrupt := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
 
 	w := &Watcher{
-		log:       log,
-		interrupt: interrupt,
-		actions:   actions,
-		watcher:   watcher,
+		log:     log,
+		actions: actions,
+		watcher: watcher,
+		fs:      vfs.OS{},
+		ctx:     ctx,
+		cancel:  cancel,
+		loop:    make(chan struct{}),
+		watched: make(map[string]bool),
+		ready:   make(map[string]bool),
+		ignore:  fsignore.NewMatcher(log, vfs.OS{}),
+		quiet:   DefaultQuietPeriod,
+		pending: make(map[string]*pendingFile),
+		handled: newHandledFiles(maxHandled),
+		busy:    make(map[string]bool),
+		settled: make(chan string),
+		done:    make(chan struct{}),
 	}
 
 	go w.watch()
@@ -40,24 +91,66 @@ func NewWatcher(log *log.Logger, actions ...model.Action) *Watcher {
 	return w
 }
 
+// WithFilesystem used for accessing the files reported by fsnotify
+// Events are always captured from the os, so fs has to be backed by it
+func (w *Watcher) WithFilesystem(fs vfs.Filesystem) *Watcher {
+	w.fs = fs
+	if w.ignore != nil {
+		w.ignore = fsignore.NewMatcher(w.log, fs)
+	}
+	return w
+}
+
+// WithIgnoreFiles sets whether the ignore files found in the dirs are honored, which they are by default
+func (w *Watcher) WithIgnoreFiles(enabled bool) *Watcher {
+	w.ignore = nil
+	if enabled {
+		w.ignore = fsignore.NewMatcher(w.log, w.fs)
+	}
+	return w
+}
+
+// WithPolicies invalidates the policy files cached by r when they are changed or removed
+func (w *Watcher) WithPolicies(r *fspolicy.Resolver) *Watcher {
+	w.policies = r
+	return w
+}
+
+// WithQuietPeriod sets the time a file has to stay unchanged after its last event before it gets handled
+func (w *Watcher) WithQuietPeriod(d time.Duration) *Watcher {
+	w.quiet = d
+	return w
+}
+
+// WithInitialSync crawls all files of a dir once after it is being watched, covering changes from before fscrub started
+// Events arriving during the crawl are deduplicated against it
+func (w *Watcher) WithInitialSync(sync bool) *Watcher {
+	w.sync = sync
+	return w
+}
+
 func (w *Watcher) watch() {
+	defer close(w.loop)
 	for {
 		select {
 		case event := <-w.watcher.Events:
+			if vfs.IsTempFile(event.Name) {
+				continue
+			}
 			w.log.Debug("file event captured", zap.String("event", event.String()))
+			if w.ignore != nil && fsignore.IsIgnoreFile(event.Name) {
+				w.reloadIgnore(event.Name)
+			}
+			if fspolicy.IsPolicyFile(event.Name) {
+				reloadPolicy(w.log, w.policies, event.Name)
+			}
 			if event.Op&fsnotify.Write == fsnotify.Write {
-				w.log.Info("handling file event", zap.String("type", "modified"), zap.String("file", event.Name))
-				err := w.handle(event.Name)
-				if err != nil {
-					w.log.Error("failed handling file event",
-						zap.String("type", ""),
-						zap.String("file", event.Name),
-					)
-				}
+				w.log.Debug("scheduling file event", zap.String("type", "modified"), zap.String("file", event.Name))
+				w.schedule(event.Name)
 			}
 			if event.Op&fsnotify.Create == fsnotify.Create {
 				w.log.Info("handling file event", zap.String("type", "created"), zap.String("file", event.Name))
-				err := w.handle(event.Name)
+				err := w.created(event.Name)
 				if err != nil {
 					w.log.Error("failed handling file event",
 						zap.String("type", ""),
@@ -65,31 +158,299 @@ func (w *Watcher) watch() {
 					)
 				}
 			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				w.removeWatches(event.Name)
+				w.forget(event.Name)
+				w.ignore.Invalidate(event.Name)
+				if w.policies != nil {
+					w.policies.Invalidate(event.Name)
+				}
+			}
+		case path := <-w.settled:
+			w.settle(path)
 		case err := <-w.watcher.Errors:
 			w.log.Error("error in fswatch", zap.Error(err))
-		case <-w.interrupt:
+		case <-w.ctx.Done():
 			return
 		}
 	}
 }
 
-// Run the watcher for dir
-func (w *Watcher) Run(dir model.Directory, erc chan error) {
-	err := w.watcher.Add(dir.String())
+// Run the watcher for dir and all its subdirectories until the watcher is stopped or ctx is done
+// Once ctx is done, events below dir are no longer handled and the initial sync is abandoned
+func (w *Watcher) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	root := filepath.Clean(dir.String())
+	w.m.Lock()
+	if w.ctx.Err() != nil {
+		w.m.Unlock()
+		return
+	}
+	w.roots = append(w.roots, root)
+	w.runs.Add(1)
+	w.m.Unlock()
+	defer w.runs.Done()
+	defer w.release(root)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := w.addWatches(root, false)
 	if err != nil {
 		erc <- err
+		return
+	}
+	if w.sync {
+		w.log.Info("starting initial sync", zap.String("dir", dir.String()))
+		err = vfs.Walk(w.fs, root, func(path string, file os.FileInfo, err error) error {
+			return w.syncFile(ctx, path, file, err)
+		})
+		if err != nil && ctx.Err() != nil {
+			w.log.Info("initial sync cancelled", zap.String("dir", dir.String()))
+			return
+		}
+		if err != nil {
+			erc <- err
+			return
+		}
+		w.log.Info("initial sync finished", zap.String("dir", dir.String()))
+	}
+	w.m.Lock()
+	w.ready[root] = true
+	w.m.Unlock()
+	<-ctx.Done()
+}
+
+// Ready reports whether the watches of dir are set up and its initial sync finished
+func (w *Watcher) Ready(dir model.Directory) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.ready[filepath.Clean(dir.String())]
+}
+
+// release root once its run ended, dropping the watches no other root needs
+func (w *Watcher) release(root string) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	for i, r := range w.roots {
+		if r == root {
+			w.roots = append(w.roots[:i], w.roots[i+1:]...)
+			break
+		}
+	}
+	delete(w.ready, root)
+	for dir := range w.watched {
+		if !primitives.Within(dir, root) || w.rooted(dir) {
+			continue
+		}
+		w.watcher.Remove(dir)
+		delete(w.watched, dir)
+		w.log.Debug("stopped watching dir", zap.String("dir", dir))
 	}
 }
 
-func (w *Watcher) handle(path string) error {
-	file, err := os.Lstat(path)
+// syncFile handles a file found by the initial sync unless the watcher already took care of it
+// Failing files are logged and skipped so the watcher keeps running
+func (w *Watcher) syncFile(ctx context.Context, path string, file os.FileInfo, err error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err != nil {
+		w.log.Error("unable to sync path", zap.String("path", path), zap.Error(err))
+		if file != nil && file.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if w.ignored(path, file.IsDir()) {
+		w.log.Debug("skipping ignored path", zap.String("path", path))
+		if file.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if file.IsDir() {
+		return nil
+	}
+	w.m.Lock()
+	_, pending := w.pending[path]
+	_, handled := w.handled.get(path)
+	if pending || handled {
+		w.m.Unlock()
+		w.log.Debug("skipping file already covered by events", zap.String("file", path))
+		return nil
+	}
+	w.busy[path] = true
+	w.m.Unlock()
+	defer func() {
+		w.m.Lock()
+		delete(w.busy, path)
+		w.m.Unlock()
+	}()
+
+	if err := w.handleFile(ctx, path, file); err != nil {
+		w.log.Error("failed syncing file", zap.String("file", path), zap.Error(err))
+		return nil
+	}
+	w.remember(path)
+	return nil
+}
+
+// created schedules a new file, new directories are watched and the files already inside are scheduled
+// as they might have been created before the watch was registered
+func (w *Watcher) created(path string) error {
+	file, err := w.fs.Lstat(path)
+	if os.IsNotExist(err) {
+		w.log.Debug("skipping vanished path", zap.String("path", path))
+		return nil
+	}
+	if err != nil {
+		w.log.Error("unable to handle path", zap.String("path", path), zap.Error(err))
+		return err
+	}
+	if w.ignored(path, file.IsDir()) {
+		w.log.Debug("skipping ignored path", zap.String("path", path))
+		return nil
+	}
+	if !file.IsDir() {
+		w.schedule(path)
+		return nil
+	}
+	return w.addWatches(path, true)
+}
+
+// addWatches registers watches for root and all directories below it, symlinks are not followed
+// If scan is set, all files found are scheduled as well. Paths excluded by ignore files are skipped
+func (w *Watcher) addWatches(root string, scan bool) error {
+	return vfs.Walk(w.fs, root, func(path string, file os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && w.ignored(path, file.IsDir()) {
+			w.log.Debug("skipping ignored path", zap.String("path", path))
+			if file.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if file.IsDir() {
+			return w.addWatch(path)
+		}
+		if scan {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) addWatch(path string) error {
+	path = filepath.Clean(path)
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.watched[path] {
+		return nil
+	}
+	if err := w.watcher.Add(path); err != nil {
+		return err
+	}
+	w.log.Debug("watching dir", zap.String("dir", path))
+	w.watched[path] = true
+	return nil
+}
+
+// removeWatches drops the watches of path and all directories below it
+func (w *Watcher) removeWatches(path string) {
+	path = filepath.Clean(path)
+	w.m.Lock()
+	defer w.m.Unlock()
+	prefix := path + string(filepath.Separator)
+	for dir := range w.watched {
+		if dir != path && !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+		// the os already dropped the watches of deleted dirs, so errors are expected
+		w.watcher.Remove(dir)
+		delete(w.watched, dir)
+		w.log.Debug("stopped watching dir", zap.String("dir", dir))
+	}
+}
+
+// ignored reports whether path is excluded by the ignore files of the root it belongs to
+// Paths outside of all roots, e.g. after their run ended, and temp files of fscrub are ignored as well
+func (w *Watcher) ignored(path string, isDir bool) bool {
+	if vfs.IsTempFile(path) {
+		return true
+	}
+	path = filepath.Clean(path)
+	w.m.Lock()
+	root := ""
+	for _, r := range w.roots {
+		if primitives.Within(path, r) && len(r) > len(root) {
+			root = r
+		}
+	}
+	w.m.Unlock()
+	if root == "" {
+		return true
+	}
+	return w.ignore.Ignored(root, path, isDir)
+}
+
+// rooted reports whether the clean path belongs to any root, w.m has to be held
+func (w *Watcher) rooted(path string) bool {
+	for _, r := range w.roots {
+		if primitives.Within(path, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadIgnore drops the cached rules of a changed ignore file
+// Dirs no longer ignored get watched, files in them are handled on their next change.
+// Dirs ignored from now on stay watched, but their events are dropped
+func (w *Watcher) reloadIgnore(path string) {
+	dir := filepath.Dir(path)
+	w.log.Info("reloading ignore file", zap.String("file", path))
+	w.ignore.Invalidate(dir)
+	w.m.Lock()
+	watched := w.watched[dir]
+	w.m.Unlock()
+	if !watched {
+		return
+	}
+	if err := w.addWatches(dir, false); err != nil {
+		w.log.Error("unable to watch dirs after reloading ignore file", zap.String("file", path), zap.Error(err))
+	}
+}
+
+// reloadPolicy invalidates the policy file at path cached by r, if any
+func reloadPolicy(log *log.Logger, r *fspolicy.Resolver, path string) {
+	if r == nil {
+		return
+	}
+	log.Info("reloading policy", zap.String("file", path))
+	r.Invalidate(filepath.Dir(path))
+}
+
+func (w *Watcher) handle(ctx context.Context, path string) error {
+	file, err := w.fs.Lstat(path)
 	if err != nil {
 		w.log.Error("unable to handle path", zap.String("path", path), zap.Error(err))
 		return err
 	}
+	return w.handleFile(ctx, path, file)
+}
+
+func (w *Watcher) handleFile(ctx context.Context, path string, file os.FileInfo) error {
 	w.log.Info("handling path", zap.String("path", path))
 	for _, a := range w.actions {
-		err := a(path, file)
+		err := a(ctx, path, file)
 		if err != nil {
 			return err
 		}
@@ -97,10 +458,18 @@ func (w *Watcher) handle(path string) error {
 	return nil
 }
 
-// Stop watching all dirs
+// Stop watching all dirs, waiting for the files in progress
 func (w *Watcher) Stop() {
-	w.log.Info("stopping watcher")
-	w.interrupt <- true
-	w.watcher.Close()
-	w.log.Info("watcher stopped")
+	w.stopOnce.Do(func() {
+		w.log.Info("stopping watcher")
+		w.m.Lock()
+		w.cancel()
+		w.m.Unlock()
+		<-w.loop
+		w.runs.Wait()
+		close(w.done)
+		w.stopPending()
+		w.watcher.Close()
+		w.log.Info("watcher stopped")
+	})
 }
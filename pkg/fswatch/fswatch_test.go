@@ -1,16 +1,24 @@
 package fswatch
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
 	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
 	"github.com/playnet-public/libs/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
-// TODO: Add tests for file system events
 func TestNewWatcher(t *testing.T) {
 	log := log.NewNop()
 	tests := []struct {
@@ -57,7 +65,7 @@ func TestNewWatcher(t *testing.T) {
 			}
 			erc := make(chan error)
 			for _, d := range tt.dirs {
-				go w.Run(d, erc)
+				go w.Run(context.Background(), d, erc)
 				select {
 				case <-time.After(time.Millisecond * 5):
 				case err := <-erc:
@@ -83,6 +91,7 @@ func TestWatcher_handle(t *testing.T) {
 			&Watcher{
 				log:     log.NewNop(),
 				actions: []model.Action{model.NoOpAction},
+				fs:      vfs.OS{},
 			},
 			"fswatch_test.go",
 			false,
@@ -92,6 +101,7 @@ func TestWatcher_handle(t *testing.T) {
 			&Watcher{
 				log:     log.NewNop(),
 				actions: []model.Action{model.NoOpAction},
+				fs:      vfs.OS{},
 			},
 			"",
 			true,
@@ -101,6 +111,7 @@ func TestWatcher_handle(t *testing.T) {
 			&Watcher{
 				log:     log.NewNop(),
 				actions: []model.Action{errorAction},
+				fs:      vfs.OS{},
 			},
 			"fswatch_test.go",
 			true,
@@ -108,13 +119,414 @@ func TestWatcher_handle(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.w.handle(tt.path); (err != nil) != tt.wantErr {
+			if err := tt.w.handle(context.Background(), tt.path); (err != nil) != tt.wantErr {
 				t.Errorf("Watcher.handle() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func errorAction(path string, file os.FileInfo) error {
+func errorAction(ctx context.Context, path string, file os.FileInfo) error {
 	return errors.New("testError")
 }
+
+// recorder is an action remembering all handled paths
+type recorder struct {
+	m     sync.Mutex
+	paths map[string]int
+}
+
+func (r *recorder) action(ctx context.Context, path string, file os.FileInfo) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.paths[path]++
+	return nil
+}
+
+func (r *recorder) count(path string) int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.paths[path]
+}
+
+func (r *recorder) wait(t *testing.T, path string) {
+	for i := 0; i < 200; i++ {
+		if r.count(path) > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Errorf("path %s was never handled", path)
+}
+
+func (w *Watcher) isWatched(path string) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.watched[path]
+}
+
+// start running w for dir in the background, waiting until it is ready
+func start(t *testing.T, w *Watcher, dir string) {
+	erc := make(chan error, 1)
+	go w.Run(context.Background(), model.Directory(dir), erc)
+	for i := 0; i < 200 && !w.Ready(model.Directory(dir)); i++ {
+		select {
+		case err := <-erc:
+			t.Fatalf("Watcher.Run() error = %v", err)
+		case <-time.After(time.Millisecond * 5):
+		}
+	}
+	if !w.Ready(model.Directory(dir)) {
+		t.Fatalf("Watcher.Run() not ready for %s", dir)
+	}
+}
+
+func TestWatcher_Recursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "2018", "02")
+	if err := os.MkdirAll(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &recorder{paths: make(map[string]int)}
+	w := NewWatcher(log.NewNop(), r.action).WithQuietPeriod(time.Millisecond * 10)
+	defer w.Stop()
+	start(t, w, dir)
+	if !w.isWatched(existing) {
+		t.Fatalf("existing subdirectory %s not watched", existing)
+	}
+
+	// files in existing subdirectories
+	file := filepath.Join(existing, "a.log")
+	if err := ioutil.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, file)
+
+	// files in new subdirectories, created before their watch exists
+	created := filepath.Join(dir, "2018", "03", "01")
+	if err := os.MkdirAll(created, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file = filepath.Join(created, "b.log")
+	if err := ioutil.WriteFile(file, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, file)
+	if !w.isWatched(created) {
+		t.Errorf("new subdirectory %s not watched", created)
+	}
+
+	// removed subdirectories
+	removed := filepath.Join(dir, "2018")
+	if err := os.RemoveAll(removed); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200 && w.isWatched(removed); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if w.isWatched(removed) || w.isWatched(existing) || w.isWatched(created) {
+		t.Errorf("removed subdirectories still watched")
+	}
+}
+
+func TestWatcher_Settle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &recorder{paths: make(map[string]int)}
+	// rewrite files like fscrub does, which must not trigger another handling
+	rewrite := func(ctx context.Context, path string, file os.FileInfo) error {
+		r.action(context.Background(), path, file)
+		return ioutil.WriteFile(path, []byte("scrubbed"), 0644)
+	}
+	w := NewWatcher(log.NewNop(), rewrite).WithQuietPeriod(time.Millisecond * 100)
+	defer w.Stop()
+	start(t, w, dir)
+
+	// a slow upload is handled once after it finished
+	name := filepath.Join(dir, "upload.log")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		file.WriteString("chunk\n")
+		time.Sleep(time.Millisecond * 20)
+	}
+	file.Close()
+	if n := r.count(name); n != 0 {
+		t.Errorf("file handled %d times while being written", n)
+	}
+	r.wait(t, name)
+	time.Sleep(time.Millisecond * 300)
+	if n := r.count(name); n != 1 {
+		t.Errorf("file handled %d times, want 1", n)
+	}
+
+	// changes by others are handled again
+	if err := ioutil.WriteFile(name, []byte("changed again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && r.count(name) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := r.count(name); n != 2 {
+		t.Errorf("file handled %d times after external change, want 2", n)
+	}
+}
+
+func TestWatcher_TempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &recorder{paths: make(map[string]int)}
+	// replace files through a temp file like vfs.OS does
+	rewrite := func(ctx context.Context, path string, file os.FileInfo) error {
+		r.action(context.Background(), path, file)
+		return vfs.OS{}.WriteFile(path, []byte("scrubbed"), 0644)
+	}
+	core, logs := observer.New(zapcore.ErrorLevel)
+	l := log.NewNop()
+	l.Logger = zap.New(core)
+	w := NewWatcher(l, rewrite).WithQuietPeriod(time.Millisecond * 50)
+	defer w.Stop()
+	start(t, w, dir)
+
+	name := filepath.Join(dir, "a.log")
+	if err := ioutil.WriteFile(name, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, name)
+	time.Sleep(time.Millisecond * 200)
+	for _, entry := range logs.All() {
+		t.Errorf("Watcher logged %q %v", entry.Message, entry.ContextMap())
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	for path, n := range r.paths {
+		if path != name || n != 1 {
+			t.Errorf("path %s handled %d times, want only %s once", path, n, name)
+		}
+	}
+}
+
+func TestWatcher_InitialSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing := []string{
+		filepath.Join(dir, "a.log"),
+		filepath.Join(dir, "2018", "02", "b.log"),
+	}
+	for _, name := range existing {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &recorder{paths: make(map[string]int)}
+	rewrite := func(ctx context.Context, path string, file os.FileInfo) error {
+		r.action(context.Background(), path, file)
+		return ioutil.WriteFile(path, []byte("scrubbed"), 0644)
+	}
+	w := NewWatcher(log.NewNop(), rewrite).WithQuietPeriod(time.Millisecond * 50).WithInitialSync(true)
+	defer w.Stop()
+	start(t, w, dir)
+
+	// the sync covers existing files once, its own writes are not handled again
+	time.Sleep(time.Millisecond * 300)
+	for _, name := range existing {
+		if n := r.count(name); n != 1 {
+			t.Errorf("existing file %s handled %d times, want 1", name, n)
+		}
+	}
+
+	// the watcher keeps running after the sync
+	name := filepath.Join(dir, "2018", "02", "c.log")
+	if err := ioutil.WriteFile(name, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, name)
+}
+
+func TestWatcher_IgnoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ignoreFile := filepath.Join(dir, ".fscrubignore")
+	if err := ioutil.WriteFile(ignoreFile, []byte("*.tmp\ncache/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &recorder{paths: make(map[string]int)}
+	w := NewWatcher(log.NewNop(), r.action).WithQuietPeriod(time.Millisecond * 10)
+	defer w.Stop()
+	start(t, w, dir)
+	if w.isWatched(cache) {
+		t.Errorf("ignored dir %s watched", cache)
+	}
+
+	ignored, kept := filepath.Join(dir, "a.tmp"), filepath.Join(dir, "a.log")
+	for _, name := range []string{ignored, kept} {
+		if err := ioutil.WriteFile(name, []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.wait(t, kept)
+	time.Sleep(time.Millisecond * 50)
+	if r.count(ignored) != 0 {
+		t.Errorf("ignored file %s handled", ignored)
+	}
+
+	// changed ignore files are reloaded
+	if err := ioutil.WriteFile(ignoreFile, []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200 && !w.isWatched(cache); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if !w.isWatched(cache) {
+		t.Errorf("dir %s not watched after reloading ignore file", cache)
+	}
+	if err := ioutil.WriteFile(ignored, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, ignored)
+	if err := ioutil.WriteFile(kept, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if r.count(kept) != 1 {
+		t.Errorf("file %s handled %d times after being ignored", kept, r.count(kept))
+	}
+}
+
+func TestWatcher_Release(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	released, kept := filepath.Join(dir, "released"), filepath.Join(dir, "kept")
+	for _, d := range []string{released, kept} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &recorder{paths: make(map[string]int)}
+	w := NewWatcher(log.NewNop(), r.action).WithQuietPeriod(time.Millisecond * 10)
+	defer w.Stop()
+	start(t, w, kept)
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		w.Run(ctx, model.Directory(released), make(chan error, 1))
+		close(returned)
+	}()
+	for i := 0; i < 200 && !w.Ready(model.Directory(released)); i++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatalf("Watcher.Run() did not return once ctx was done")
+	}
+	if w.isWatched(released) || w.Ready(model.Directory(released)) {
+		t.Errorf("released dir %s still watched", released)
+	}
+
+	ignored, handled := filepath.Join(released, "a.log"), filepath.Join(kept, "a.log")
+	for _, name := range []string{ignored, handled} {
+		if err := ioutil.WriteFile(name, []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.wait(t, handled)
+	time.Sleep(time.Millisecond * 50)
+	if r.count(ignored) != 0 {
+		t.Errorf("file %s of released dir handled", ignored)
+	}
+}
+
+func TestWatcher_Policies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join(sub, fspolicy.FileName)
+	if err := ioutil.WriteFile(policy, []byte("action: report\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(sub, "a.log")
+	policies := fspolicy.NewResolver(log.NewNop(), vfs.OS{}, dir)
+	action := func() fspolicy.Action {
+		p, err := policies.Resolve(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Action
+	}
+	if got := action(); got != fspolicy.ActionReport {
+		t.Fatalf("Resolve() action = %s, want %s", got, fspolicy.ActionReport)
+	}
+
+	// the scrub action never sees removed policy files, the watcher has to invalidate them
+	w := NewWatcher(log.NewNop()).WithQuietPeriod(time.Millisecond * 10).WithPolicies(policies)
+	defer w.Stop()
+	start(t, w, dir)
+	if err := os.Remove(policy); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && action() != fspolicy.ActionScrub; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if got := action(); got != fspolicy.ActionScrub {
+		t.Errorf("Resolve() action after removing the policy = %s, want %s", got, fspolicy.ActionScrub)
+	}
+}
+
+func TestWatcher_forget(t *testing.T) {
+	w := NewWatcher(log.NewNop())
+	defer w.Stop()
+	for _, path := range []string{"data/a.log", "data/sub/b.log", "data2/c.log"} {
+		w.handled.put(path, fileState{})
+	}
+	w.pending["data/sub/d.log"] = &pendingFile{timer: time.AfterFunc(time.Hour, func() {})}
+
+	// removing a dir forgets all files below it
+	w.forget("data/")
+	w.m.Lock()
+	defer w.m.Unlock()
+	if _, ok := w.handled.get("data2/c.log"); !ok || w.handled.len() != 1 || len(w.pending) != 0 {
+		t.Errorf("Watcher.forget() kept %d handled and %d pending files, want data2/c.log only", w.handled.len(), len(w.pending))
+	}
+}
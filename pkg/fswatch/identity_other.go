@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package fswatch
+
+import "os"
+
+// identify is not supported on this platform, so renames are reported as new files
+func identify(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,377 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/playnet-public/libs/log"
+
+	"github.com/playnet-public/fscrub/pkg/fsignore"
+	"github.com/playnet-public/fscrub/pkg/fspolicy"
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"go.uber.org/zap"
+)
+
+// DefaultPollInterval between two scans of a polled dir
+const DefaultPollInterval = 10 * time.Second
+
+// Poller is a handler detecting changes by scanning dirs periodically
+// Unlike the Watcher it sees changes made by other hosts on network file systems like NFS or SMB.
+// A changed file is handled once it stayed unchanged from one scan to the next.
+// Failed scans are logged and retried on the next tick, keeping the result of the last good one
+type Poller struct {
+	log      *log.Logger
+	actions  []model.Action
+	fs       vfs.Filesystem
+	interval time.Duration
+	// maxFailures of consecutive scans before the run fails, 0 retries until stopped
+	maxFailures int
+	ignoreFiles bool
+	// sync handles all files found by the first scan
+	sync bool
+	// policies are invalidated whenever policy files change
+	policies *fspolicy.Resolver
+
+	// stop cancels all runs, runs waits for them to return
+	m       sync.Mutex
+	stopped bool
+	stop    chan struct{}
+	runs    sync.WaitGroup
+}
+
+// NewPoller with logger
+func NewPoller(log *log.Logger, actions ...model.Action) *Poller {
+	return &Poller{
+		log:         log,
+		actions:     actions,
+		fs:          vfs.OS{},
+		interval:    DefaultPollInterval,
+		ignoreFiles: true,
+		stop:        make(chan struct{}),
+	}
+}
+
+// WithFilesystem scans fs instead of the os file system
+func (p *Poller) WithFilesystem(fs vfs.Filesystem) *Poller {
+	p.fs = fs
+	return p
+}
+
+// WithInterval sets the time between two scans, which is also the time a file has to stay unchanged before it gets handled
+func (p *Poller) WithInterval(d time.Duration) *Poller {
+	p.interval = d
+	return p
+}
+
+// WithIgnoreFiles sets whether the ignore files found in the dirs are honored, which they are by default
+func (p *Poller) WithIgnoreFiles(enabled bool) *Poller {
+	p.ignoreFiles = enabled
+	return p
+}
+
+// WithMaxFailures fails the run of a dir once n scans in a row failed, 0 keeps retrying until it is stopped
+func (p *Poller) WithMaxFailures(n int) *Poller {
+	p.maxFailures = n
+	return p
+}
+
+// WithInitialSync handles all files found by the first scan of a dir, covering changes from before fscrub started
+func (p *Poller) WithInitialSync(sync bool) *Poller {
+	p.sync = sync
+	return p
+}
+
+// WithPolicies invalidates the policy files cached by r when they are changed or removed
+func (p *Poller) WithPolicies(r *fspolicy.Resolver) *Poller {
+	p.policies = r
+	return p
+}
+
+// fileID identifies a file independent of the path leading to it
+type fileID struct {
+	dev, ino uint64
+}
+
+// polledFile is what a scan found out about a file
+type polledFile struct {
+	info       os.FileInfo
+	state      fileState
+	id         fileID
+	identified bool
+}
+
+func newPolledFile(info os.FileInfo) polledFile {
+	id, ok := identify(info)
+	return polledFile{info: info, state: stateOf(info), id: id, identified: ok}
+}
+
+// poll is the state of a single polled dir
+type poll struct {
+	p    *Poller
+	root string
+	// files found by the last good scan, nil until the first one succeeded
+	files map[string]polledFile
+	// pending files changed during a scan and get handled if they are unchanged on the next one
+	pending map[string]bool
+	// handled files and their state afterwards, so own writes are not handled again
+	handled map[string]fileState
+}
+
+// Run the poller for dir until it is stopped or ctx is done
+func (p *Poller) Run(ctx context.Context, dir model.Directory, erc chan error) {
+	p.m.Lock()
+	if p.stopped {
+		p.m.Unlock()
+		return
+	}
+	p.runs.Add(1)
+	p.m.Unlock()
+	defer p.runs.Done()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	p.log.Info("start handling", zap.String("dir", dir.String()), zap.String("handler", "poller"))
+	defer p.log.Info("stop handling", zap.String("dir", dir.String()), zap.String("handler", "poller"))
+	pl := &poll{
+		p:       p,
+		root:    filepath.Clean(dir.String()),
+		pending: make(map[string]bool),
+		handled: make(map[string]fileState),
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		var err error
+		if pl.files == nil {
+			err = pl.first(ctx)
+		} else {
+			err = pl.update(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+		} else {
+			failures++
+			p.log.Error("failed polling dir",
+				zap.String("dir", dir.String()),
+				zap.Int("failures", failures),
+				zap.Error(err),
+			)
+			if p.maxFailures > 0 && failures >= p.maxFailures {
+				erc <- err
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// first scan of the dir, handling all files found if an initial sync is requested
+func (pl *poll) first(ctx context.Context) error {
+	files, err := pl.scan(ctx)
+	if err != nil {
+		return err
+	}
+	pl.files = files
+	if !pl.p.sync {
+		return nil
+	}
+	pl.p.log.Info("starting initial sync", zap.String("dir", pl.root))
+	for _, path := range sortedFiles(files) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		pl.handle(ctx, path, "synced")
+	}
+	pl.p.log.Info("initial sync finished", zap.String("dir", pl.root))
+	return nil
+}
+
+// Stop polling all dirs, waiting for the files in progress
+func (p *Poller) Stop() {
+	p.m.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	p.m.Unlock()
+	p.runs.Wait()
+}
+
+// scan all regular files below the dir, skipping temp files of fscrub and those excluded by ignore files
+// Entries below dirs failing to be read are taken over from the last scan, so they are not mistaken for new files later
+func (pl *poll) scan(ctx context.Context) (map[string]polledFile, error) {
+	var ignore *fsignore.Matcher
+	if pl.p.ignoreFiles {
+		ignore = fsignore.NewMatcher(pl.p.log, pl.p.fs)
+	}
+	files := make(map[string]polledFile)
+	var failed []string
+	err := vfs.Walk(pl.p.fs, pl.root, func(path string, info os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if path == pl.root {
+				return err
+			}
+			pl.p.log.Error("unable to poll path", zap.String("path", path), zap.Error(err))
+			failed = append(failed, path)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path != pl.root && ignore.Ignored(pl.root, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !vfs.IsTempFile(path) {
+			files[path] = newPolledFile(info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for path, f := range pl.files {
+		for _, dir := range failed {
+			if primitives.Within(path, dir) {
+				files[path] = f
+				break
+			}
+		}
+	}
+	return files, nil
+}
+
+// update the dir by scanning it again, files unchanged since they changed are handled
+// If the scan fails, nothing is changed so the next update compares against the last good scan
+func (pl *poll) update(ctx context.Context) error {
+	files, err := pl.scan(ctx)
+	if err != nil {
+		return err
+	}
+	// files which vanished, by identity to tell renamed files apart from new ones
+	vanished := make(map[fileID]string)
+	for path, f := range pl.files {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		delete(pl.pending, path)
+		delete(pl.handled, path)
+		if fspolicy.IsPolicyFile(path) {
+			reloadPolicy(pl.p.log, pl.p.policies, path)
+		}
+		if f.identified {
+			vanished[f.id] = path
+		}
+	}
+
+	changed := make(map[string]bool)
+	for _, path := range sortedFiles(files) {
+		f := files[path]
+		last, seen := pl.files[path]
+		switch {
+		case !seen:
+			if from, ok := vanished[f.id]; ok && f.identified {
+				pl.p.log.Debug("scheduling file event", zap.String("type", "renamed"), zap.String("from", from), zap.String("file", path))
+			} else {
+				pl.p.log.Debug("scheduling file event", zap.String("type", "created"), zap.String("file", path))
+			}
+		case last.state != f.state:
+			pl.p.log.Debug("scheduling file event", zap.String("type", "modified"), zap.String("file", path))
+		default:
+			continue
+		}
+		if fspolicy.IsPolicyFile(path) {
+			reloadPolicy(pl.p.log, pl.p.policies, path)
+		}
+		changed[path] = true
+		pl.pending[path] = true
+	}
+	pl.files = files
+
+	for _, path := range sortedPaths(pl.pending) {
+		if changed[path] {
+			continue
+		}
+		delete(pl.pending, path)
+		// the file looks exactly like after it was handled last, e.g. because fscrub rewrote it
+		if last, ok := pl.handled[path]; ok && last == files[path].state {
+			pl.p.log.Debug("ignoring change caused by own write", zap.String("file", path))
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		pl.handle(ctx, path, "settled")
+	}
+	return nil
+}
+
+// handle a file found by the last scan, remembering its state afterwards
+// Failing files are logged, they are handled again once they change
+func (pl *poll) handle(ctx context.Context, path, event string) {
+	f := pl.files[path]
+	pl.p.log.Info("handling file event", zap.String("type", event), zap.String("file", path))
+	for _, a := range pl.p.actions {
+		if err := a(ctx, path, f.info); err != nil {
+			pl.p.log.Error("failed handling file event",
+				zap.String("type", event),
+				zap.String("file", path),
+				zap.Error(err),
+			)
+			return
+		}
+	}
+	info, err := pl.p.fs.Lstat(path)
+	if err != nil {
+		return
+	}
+	pl.handled[path] = stateOf(info)
+	pl.files[path] = newPolledFile(info)
+}
+
+// sortedFiles returns the paths of files in lexical order
+func sortedFiles(files map[string]polledFile) []string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// sortedPaths returns the paths of set in lexical order
+func sortedPaths(set map[string]bool) []string {
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
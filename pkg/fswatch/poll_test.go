@@ -0,0 +1,183 @@
+package fswatch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/model"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"github.com/playnet-public/libs/log"
+)
+
+func TestPoller(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".fscrubignore"), []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(dir, "existing.log")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &recorder{paths: make(map[string]int)}
+	// rewrite logs like fscrub does, which must not trigger another handling
+	rewrite := func(ctx context.Context, path string, file os.FileInfo) error {
+		r.action(ctx, path, file)
+		if filepath.Ext(path) != ".log" {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte("scrubbed"), 0644)
+	}
+	p := NewPoller(log.NewNop(), rewrite).WithInterval(time.Millisecond * 20).WithInitialSync(true)
+	erc := make(chan error, 1)
+	go p.Run(context.Background(), model.Directory(dir), erc)
+	defer p.Stop()
+	// the initial sync handles the files found by the first scan
+	r.wait(t, existing)
+
+	created := filepath.Join(dir, "sub", "created.log")
+	if err := ioutil.WriteFile(created, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ignored := filepath.Join(dir, "ignored.tmp")
+	if err := ioutil.WriteFile(ignored, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, created)
+
+	// changes by others are handled again, own writes are not
+	if err := ioutil.WriteFile(existing, []byte("changed again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && r.count(existing) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	renamed := filepath.Join(dir, "renamed.log")
+	if err := os.Rename(created, renamed); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, renamed)
+	time.Sleep(time.Millisecond * 100)
+
+	want := map[string]int{existing: 2, created: 1, renamed: 1, ignored: 0}
+	for path, n := range want {
+		if got := r.count(path); got != n {
+			t.Errorf("%s handled %d times, want %d", path, got, n)
+		}
+	}
+	select {
+	case err := <-erc:
+		t.Errorf("Poller.Run() error = %v", err)
+	default:
+	}
+}
+
+// flakyFS fails reading dirs while failures are left, like a share being briefly unavailable
+type flakyFS struct {
+	vfs.Filesystem
+	failures int32
+}
+
+func (fs *flakyFS) ReadDir(name string) ([]os.FileInfo, error) {
+	if atomic.AddInt32(&fs.failures, -1) >= 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.EIO}
+	}
+	return fs.Filesystem.ReadDir(name)
+}
+
+func TestPoller_FailedScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "existing.log")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &recorder{paths: make(map[string]int)}
+	fs := &flakyFS{Filesystem: vfs.OS{}, failures: 1}
+	p := NewPoller(log.NewNop(), r.action).
+		WithFilesystem(fs).
+		WithInterval(time.Millisecond * 20).
+		WithInitialSync(true)
+	erc := make(chan error, 1)
+	go p.Run(context.Background(), model.Directory(dir), erc)
+	defer p.Stop()
+	// the failed first scan is retried
+	r.wait(t, existing)
+
+	// a failed scan later on keeps the last good one, so existing files are not mistaken for new ones
+	atomic.StoreInt32(&fs.failures, 1)
+	time.Sleep(time.Millisecond * 60)
+	created := filepath.Join(dir, "created.log")
+	if err := ioutil.WriteFile(created, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, created)
+	if n := r.count(existing); n != 1 {
+		t.Errorf("%s handled %d times, want 1", existing, n)
+	}
+	select {
+	case err := <-erc:
+		t.Errorf("Poller.Run() error = %v", err)
+	default:
+	}
+}
+
+func TestPoller_Run(t *testing.T) {
+	p := NewPoller(log.NewNop(), func(ctx context.Context, path string, file os.FileInfo) error {
+		return errors.New("testError")
+	})
+
+	// a missing dir fails the run once it failed too often
+	p.WithInterval(time.Millisecond * 10).WithMaxFailures(3)
+	erc := make(chan error, 1)
+	p.Run(context.Background(), "/nonexistent/fscrub", erc)
+	select {
+	case err := <-erc:
+		if err == nil {
+			t.Errorf("Poller.Run() error = nil for missing dir")
+		}
+	default:
+		t.Errorf("Poller.Run() returned without error for missing dir")
+	}
+
+	dir, err := ioutil.TempDir("", "fswatchTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		p.Run(ctx, model.Directory(dir), make(chan error))
+		close(returned)
+	}()
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Errorf("Poller.Run() did not return once ctx was done")
+	}
+
+	p.Stop()
+	p.Stop()
+	p.Run(context.Background(), model.Directory(dir), make(chan error))
+}
@@ -0,0 +1,208 @@
+package fswatch
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/primitives"
+	"go.uber.org/zap"
+)
+
+// DefaultQuietPeriod a file has to stay unchanged before it gets handled
+// fsnotify does not report when a writer closed the file, so this is what tells uploads in progress apart
+const DefaultQuietPeriod = time.Second
+
+// fileState is what changes whenever a file gets written
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func stateOf(file os.FileInfo) fileState {
+	return fileState{size: file.Size(), modTime: file.ModTime()}
+}
+
+// pendingFile is a file waiting for its quiet period to pass
+type pendingFile struct {
+	timer *time.Timer
+	state fileState
+}
+
+// schedule handling path once no events arrived for the quiet period
+// Bursts of events for the same path are coalesced into a single handling
+func (w *Watcher) schedule(path string) {
+	if w.ignored(path, false) {
+		w.log.Debug("skipping ignored path", zap.String("path", path))
+		return
+	}
+	var state fileState
+	if file, err := w.fs.Lstat(path); err == nil {
+		state = stateOf(file)
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	if p, ok := w.pending[path]; ok {
+		p.state = state
+		p.timer.Reset(w.quiet)
+		return
+	}
+	w.pending[path] = &pendingFile{
+		state: state,
+		timer: time.AfterFunc(w.quiet, func() {
+			select {
+			case w.settled <- path:
+			case <-w.done:
+			}
+		}),
+	}
+}
+
+// settle handles path if it did not change during the quiet period
+// Files still being written without events (e.g. on network file systems) or being handled
+// by the initial sync are scheduled again
+func (w *Watcher) settle(path string) {
+	file, err := w.fs.Lstat(path)
+	w.m.Lock()
+	p, ok := w.pending[path]
+	if !ok {
+		w.m.Unlock()
+		return
+	}
+	if err != nil {
+		delete(w.pending, path)
+		w.m.Unlock()
+		w.log.Debug("dropping event of vanished file", zap.String("file", path), zap.Error(err))
+		return
+	}
+	state := stateOf(file)
+	if state != p.state || w.busy[path] {
+		p.state = state
+		p.timer.Reset(w.quiet)
+		w.m.Unlock()
+		w.log.Debug("file still changing", zap.String("file", path))
+		return
+	}
+	delete(w.pending, path)
+	last, seen := w.handled.get(path)
+	w.m.Unlock()
+
+	// the file looks exactly like after it was handled last, e.g. because fscrub rewrote it
+	if seen && last == state {
+		w.log.Debug("ignoring event caused by own write", zap.String("file", path))
+		return
+	}
+
+	w.log.Info("handling file event", zap.String("type", "settled"), zap.String("file", path))
+	if err := w.handleFile(w.ctx, path, file); err != nil {
+		w.log.Error("failed handling file event",
+			zap.String("type", "settled"),
+			zap.String("file", path),
+			zap.Error(err),
+		)
+		return
+	}
+	w.remember(path)
+}
+
+// remember the state of path after handling it
+func (w *Watcher) remember(path string) {
+	if file, err := w.fs.Lstat(path); err == nil {
+		w.m.Lock()
+		w.handled.put(path, stateOf(file))
+		w.m.Unlock()
+	}
+}
+
+// forget everything known about a removed path and, if it was a dir, all paths below it
+func (w *Watcher) forget(path string) {
+	path = filepath.Clean(path)
+	w.m.Lock()
+	defer w.m.Unlock()
+	for p, pending := range w.pending {
+		if primitives.Within(p, path) {
+			pending.timer.Stop()
+			delete(w.pending, p)
+		}
+	}
+	w.handled.removeWithin(path)
+}
+
+func (w *Watcher) stopPending() {
+	w.m.Lock()
+	defer w.m.Unlock()
+	for path, p := range w.pending {
+		p.timer.Stop()
+		delete(w.pending, path)
+	}
+}
+
+// maxHandled limits the files the watchers remember as handled
+// Removed files are not always reported, e.g. never by fanotify or for rotated logs in dirs no longer watched,
+// so without a limit the files of a whole volume would pile up
+const maxHandled = 1 << 16
+
+// handledFiles remembers the state of the most recently handled files, evicting the least recently used ones
+type handledFiles struct {
+	limit   int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type handledFile struct {
+	path  string
+	state fileState
+}
+
+func newHandledFiles(limit int) *handledFiles {
+	return &handledFiles{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (h *handledFiles) get(path string) (fileState, bool) {
+	e, ok := h.entries[path]
+	if !ok {
+		return fileState{}, false
+	}
+	h.order.MoveToFront(e)
+	return e.Value.(*handledFile).state, true
+}
+
+func (h *handledFiles) put(path string, state fileState) {
+	if e, ok := h.entries[path]; ok {
+		e.Value.(*handledFile).state = state
+		h.order.MoveToFront(e)
+		return
+	}
+	h.entries[path] = h.order.PushFront(&handledFile{path: path, state: state})
+	if h.order.Len() > h.limit {
+		oldest := h.order.Back()
+		h.order.Remove(oldest)
+		delete(h.entries, oldest.Value.(*handledFile).path)
+	}
+}
+
+func (h *handledFiles) remove(path string) {
+	if e, ok := h.entries[path]; ok {
+		h.order.Remove(e)
+		delete(h.entries, path)
+	}
+}
+
+// removeWithin removes dir and all files below it
+func (h *handledFiles) removeWithin(dir string) {
+	for path, e := range h.entries {
+		if primitives.Within(path, dir) {
+			h.order.Remove(e)
+			delete(h.entries, path)
+		}
+	}
+}
+
+func (h *handledFiles) len() int {
+	return h.order.Len()
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"os"
 	"strings"
 	"time"
@@ -31,8 +32,9 @@ func (d *Directories) Set(value string) error {
 }
 
 // Handler defines the functions for handling directories
+// Run returns once ctx is done, Stop cancels all runs and waits for the files in progress
 type Handler interface {
-	Run(dir Directory, erc chan error)
+	Run(ctx context.Context, dir Directory, erc chan error)
 	Stop()
 }
 
@@ -42,9 +44,11 @@ type NoOpHandler struct {
 }
 
 // Run the NoOpHandler while sleeping for 1 Sec every run
-func (h *NoOpHandler) Run(dir Directory, erc chan error) {
+func (h *NoOpHandler) Run(ctx context.Context, dir Directory, erc chan error) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case erc <- nil:
 			time.Sleep(time.Millisecond * 5)
 		case <-h.interrupt:
@@ -60,9 +64,10 @@ func (h *NoOpHandler) Stop() {
 }
 
 // Action defines the functions for processing files
-type Action func(path string, file os.FileInfo) error
+// Actions cancelled through ctx must leave the file unchanged or finish changing it
+type Action func(ctx context.Context, path string, file os.FileInfo) error
 
 // NoOpAction does nothing
-func NoOpAction(path string, file os.FileInfo) error {
+func NoOpAction(ctx context.Context, path string, file os.FileInfo) error {
 	return nil
 }
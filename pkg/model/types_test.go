@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
@@ -61,7 +62,7 @@ func TestNoOpHandler(t *testing.T) {
 	erc := make(chan error)
 	count := 0
 
-	go handler.Run("", erc)
+	go handler.Run(context.Background(), "", erc)
 
 	for {
 		select {
@@ -100,7 +101,7 @@ func TestNoOpAction(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := NoOpAction(tt.args.path, tt.args.file); (err != nil) != tt.wantErr {
+			if err := NoOpAction(context.Background(), tt.args.path, tt.args.file); (err != nil) != tt.wantErr {
 				t.Errorf("NoOpAction() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
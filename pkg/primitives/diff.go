@@ -0,0 +1,279 @@
+package primitives
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DiffContext is the default number of unchanged lines surrounding a hunk
+const DiffContext = 3
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffEdit struct {
+	op   diffOp
+	text string
+}
+
+// UnifiedDiff returns the unified diff transforming lines a into lines b
+// An empty string is returned if both are equal
+func UnifiedDiff(fromFile, toFile string, a, b []string, context int) string {
+	edits := diffEdits(a, b)
+	changed := false
+	for _, e := range edits {
+		if e.op != diffEqual {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n", fromFile)
+	fmt.Fprintf(&buf, "+++ %s\n", toFile)
+
+	// position of every edit inside a and b
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.op != diffInsert {
+			aPos[i+1]++
+		}
+		if e.op != diffDelete {
+			bPos[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(edits) {
+		// find start of next change
+		for i < len(edits) && edits[i].op == diffEqual {
+			i++
+		}
+		if i >= len(edits) {
+			break
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// extend hunk while changes are closer than 2*context lines
+		end := i
+		for end < len(edits) {
+			if edits[end].op != diffEqual {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].op == diffEqual {
+				next++
+			}
+			if next < len(edits) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end = end + context
+			if end > next {
+				end = next
+			}
+			break
+		}
+
+		aStart, aLen := aPos[start], aPos[end]-aPos[start]
+		bStart, bLen := bPos[start], bPos[end]-bPos[start]
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, e := range edits[start:end] {
+			switch e.op {
+			case diffEqual:
+				buf.WriteString(" ")
+			case diffDelete:
+				buf.WriteString("-")
+			case diffInsert:
+				buf.WriteString("+")
+			}
+			buf.WriteString(e.text)
+			buf.WriteString("\n")
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// diffMaxCost bounds the edit distance searched for by diffEdits in each half of a segment,
+// segments differing in more lines are aligned at their end instead
+const diffMaxCost = 1024
+
+// diffEdits computes the shortest edit script using the linear space variant of the myers algorithm
+// Deleted lines are placed before the lines inserted in their place
+func diffEdits(a, b []string) []diffEdit {
+	edits := make([]diffEdit, 0, len(a)+len(b))
+	edits = diffSegment(edits, a, b)
+
+	// order each run of changes as deletions followed by insertions
+	for i := 0; i < len(edits); {
+		if edits[i].op == diffEqual {
+			i++
+			continue
+		}
+		j := i
+		var inserted []diffEdit
+		for ; j < len(edits) && edits[j].op != diffEqual; j++ {
+			if edits[j].op == diffInsert {
+				inserted = append(inserted, edits[j])
+			}
+		}
+		k := i
+		for _, e := range edits[i:j] {
+			if e.op == diffDelete {
+				edits[k] = e
+				k++
+			}
+		}
+		copy(edits[k:j], inserted)
+		i = j
+	}
+	return edits
+}
+
+// diffSegment appends the edits transforming a into b
+func diffSegment(edits []diffEdit, a, b []string) []diffEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for _, line := range a[:prefix] {
+		edits = append(edits, diffEdit{diffEqual, line})
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			edits = append(edits, diffEdit{diffInsert, line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			edits = append(edits, diffEdit{diffDelete, line})
+		}
+	default:
+		x, y, u, v, ok := middleSnake(a, b)
+		if !ok {
+			edits = alignEnd(edits, a, b)
+			break
+		}
+		edits = diffSegment(edits, a[:x], b[:y])
+		for _, line := range a[x:u] {
+			edits = append(edits, diffEdit{diffEqual, line})
+		}
+		edits = diffSegment(edits, a[u:], b[v:])
+	}
+
+	for _, line := range common {
+		edits = append(edits, diffEdit{diffEqual, line})
+	}
+	return edits
+}
+
+// middleSnake returns the snake from (x, y) to (u, v) in the middle of the shortest edit script
+// by searching forward from the start and backward from the end at the same time.
+// ok is false if the edit script is too expensive to be searched for
+func middleSnake(a, b []string) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	if max > diffMaxCost {
+		max = diffMaxCost
+	}
+	offset := max + 1
+	// furthest x on each diagonal k = x - y, backward on the reversed lines
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// the reversed diagonal the backward search reached in its previous step
+			if r := delta - k; odd && r >= -(d-1) && r <= d-1 && x+backward[offset+r] >= n {
+				return startX, startY, x, y, true
+			}
+		}
+		for r := -d; r <= d; r += 2 {
+			var x int
+			if r == -d || (r != d && backward[offset+r-1] < backward[offset+r+1]) {
+				x = backward[offset+r+1]
+			} else {
+				x = backward[offset+r-1] + 1
+			}
+			y := x - r
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+r] = x
+			if k := delta - r; !odd && k >= -d && k <= d && x+forward[offset+k] >= n {
+				return n - x, m - y, n - startX, m - startY, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// alignEnd appends edits pairing the lines of a and b from their end
+// Scrubbing replaces lines one by one and prepends the header, so this matches its changes exactly
+func alignEnd(edits []diffEdit, a, b []string) []diffEdit {
+	for len(a) > len(b) {
+		edits = append(edits, diffEdit{diffDelete, a[0]})
+		a = a[1:]
+	}
+	for len(b) > len(a) {
+		edits = append(edits, diffEdit{diffInsert, b[0]})
+		b = b[1:]
+	}
+	for i := range a {
+		if a[i] == b[i] {
+			edits = append(edits, diffEdit{diffEqual, a[i]})
+			continue
+		}
+		edits = append(edits, diffEdit{diffDelete, a[i]}, diffEdit{diffInsert, b[i]})
+	}
+	return edits
+}
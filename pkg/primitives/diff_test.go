@@ -0,0 +1,120 @@
+package primitives
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want string
+	}{
+		{
+			"equal",
+			[]string{"foo", "bar"},
+			[]string{"foo", "bar"},
+			"",
+		},
+		{
+			"replace",
+			[]string{"a", "foo", "c"},
+			[]string{"a", "bar", "c"},
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-foo\n+bar\n c\n",
+		},
+		{
+			"insertTop",
+			[]string{"a", "b"},
+			[]string{"header", "a", "b"},
+			"--- old\n+++ new\n@@ -1,2 +1,3 @@\n+header\n a\n b\n",
+		},
+		{
+			"fromEmpty",
+			[]string{},
+			[]string{"a"},
+			"--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			"separateHunks",
+			[]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"},
+			[]string{"x", "2", "3", "4", "5", "6", "7", "8", "9", "y"},
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+		},
+		{
+			"moved",
+			[]string{"a", "b", "c", "d"},
+			[]string{"b", "c", "a", "d"},
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n b\n c\n+a\n d\n",
+		},
+		{
+			"mergedHunks",
+			[]string{"1", "2", "3", "4", "5"},
+			[]string{"x", "2", "3", "4", "y"},
+			"--- old\n+++ new\n@@ -1,5 +1,5 @@\n-1\n+x\n 2\n 3\n 4\n-5\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("old", "new", tt.a, tt.b, DiffContext); got != tt.want {
+				t.Errorf("UnifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffEdits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		l := make([]string, r.Intn(30))
+		for i := range l {
+			l[i] = string('a' + rune(r.Intn(4)))
+		}
+		return l
+	}
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		var gotA, gotB []string
+		for _, e := range diffEdits(a, b) {
+			if e.op != diffInsert {
+				gotA = append(gotA, e.text)
+			}
+			if e.op != diffDelete {
+				gotB = append(gotB, e.text)
+			}
+		}
+		if strings.Join(gotA, ",") != strings.Join(a, ",") || strings.Join(gotB, ",") != strings.Join(b, ",") {
+			t.Fatalf("diffEdits(%v, %v) does not transform a into b", a, b)
+		}
+	}
+}
+
+func TestUnifiedDiff_Large(t *testing.T) {
+	// every line changed below a new header, like a scrubbed log
+	a := make([]string, 6000)
+	b := []string{"header", "header"}
+	for i := range a {
+		a[i] = fmt.Sprintf("%d connect from 10.0.0.%d", i, i%256)
+		b = append(b, fmt.Sprintf("%d connect from ***", i))
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diff := UnifiedDiff("old", "new", a, b, DiffContext)
+	runtime.ReadMemStats(&after)
+
+	if lines := strings.Count(diff, "\n"); lines != 3+len(a)+len(b) {
+		t.Errorf("UnifiedDiff() has %d lines, want %d", lines, 3+len(a)+len(b))
+	}
+	if !strings.HasPrefix(diff, "--- old\n+++ new\n@@ -1,6000 +1,6002 @@\n-0 connect") ||
+		!strings.Contains(diff, "\n-5999 connect from 10.0.0.111\n+header\n+header\n+0 connect from ***\n") {
+		t.Errorf("UnifiedDiff() = %q..., want a single hunk replacing all lines", diff[:100])
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("UnifiedDiff() allocated %d MiB", alloc>>20)
+	}
+}
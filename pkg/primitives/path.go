@@ -0,0 +1,19 @@
+package primitives
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Within reports whether the clean path is dir or below it
+// The relative dir "." contains all relative paths not leaving it
+func Within(path, dir string) bool {
+	if dir == "." {
+		return !filepath.IsAbs(path) && path != ".." && !strings.HasPrefix(path, ".."+string(filepath.Separator))
+	}
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return path == dir || strings.HasPrefix(path, prefix)
+}
@@ -0,0 +1,30 @@
+package primitives
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		path string
+		dir  string
+		want bool
+	}{
+		{"/logs", "/logs", true},
+		{"/logs/a.log", "/logs", true},
+		{"/logs/old/a.log", "/logs", true},
+		{"/logs2/a.log", "/logs", false},
+		{"/a.log", "/", true},
+		{"logs/a.log", ".", true},
+		{"..", ".", false},
+		{"../a.log", ".", false},
+		{"/logs/a.log", ".", false},
+	}
+	for _, tt := range tests {
+		path, dir := filepath.FromSlash(tt.path), filepath.FromSlash(tt.dir)
+		if got := Within(path, dir); got != tt.want {
+			t.Errorf("Within(%q, %q) = %v, want %v", path, dir, got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package primitives
 
+import (
+	"sort"
+)
+
 // BuildHeader returns []string containing information about fscrub
 func BuildHeader() []string {
 	return []string{
@@ -11,7 +15,60 @@ func BuildHeader() []string {
 	}
 }
 
+// PatternSetPrefix starts the header line recording the pattern set a file was scrubbed with
+const PatternSetPrefix = "// Pattern set used by fscrub: "
+
+// BuildHeaderWithPatternSet returns the header of BuildHeader including the fingerprint of the pattern set
+func BuildHeaderWithPatternSet(fingerprint string) []string {
+	header := BuildHeader()
+	last := len(header) - 1
+	return append(header[:last:last], PatternSetPrefix+fingerprint, header[last])
+}
+
 // BuildIgnoreHeader returns the header required to make fscrub ignore a file
 func BuildIgnoreHeader() string {
 	return "//-ignore: github.com/playnet-public/fscrub"
 }
+
+// RedactMask replaces sensitive text in logs and reports
+const RedactMask = "***"
+
+// Redact returns s with all byte ranges in spans replaced by RedactMask
+// Spans must be sorted and must not overlap, as returned by regexp.FindAllStringIndex
+func Redact(s string, spans [][]int) string {
+	var out []byte
+	last := 0
+	for _, span := range spans {
+		if len(span) < 2 || span[0] < last || span[1] > len(s) || span[0] > span[1] {
+			continue
+		}
+		out = append(out, s[last:span[0]]...)
+		out = append(out, RedactMask...)
+		last = span[1]
+	}
+	out = append(out, s[last:]...)
+	return string(out)
+}
+
+// MergeSpans sorts spans and joins overlapping or adjacent ones so they can be passed to Redact
+func MergeSpans(spans [][]int) [][]int {
+	var valid [][]int
+	for _, span := range spans {
+		if len(span) >= 2 && span[0] <= span[1] {
+			valid = append(valid, []int{span[0], span[1]})
+		}
+	}
+	sort.Slice(valid, func(i, j int) bool { return valid[i][0] < valid[j][0] })
+	var merged [][]int
+	for _, span := range valid {
+		last := len(merged) - 1
+		if last >= 0 && span[0] <= merged[last][1] {
+			if span[1] > merged[last][1] {
+				merged[last][1] = span[1]
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
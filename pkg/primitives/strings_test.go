@@ -0,0 +1,49 @@
+package primitives
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		spans [][]int
+		want  string
+	}{
+		{"noSpans", "foo bar", nil, "foo bar"},
+		{"single", "foo bar", [][]int{{4, 7}}, "foo ***"},
+		{"multiple", "foo bar foo", [][]int{{0, 3}, {8, 11}}, "*** bar ***"},
+		{"whole", "secret", [][]int{{0, 6}}, "***"},
+		{"invalid", "foo", [][]int{{2, 10}}, "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.s, tt.spans); got != tt.want {
+				t.Errorf("Redact() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSpans(t *testing.T) {
+	tests := []struct {
+		name  string
+		spans [][]int
+		want  [][]int
+	}{
+		{"empty", nil, nil},
+		{"sorted", [][]int{{5, 7}, {0, 2}}, [][]int{{0, 2}, {5, 7}}},
+		{"overlap", [][]int{{0, 4}, {2, 6}, {6, 8}}, [][]int{{0, 8}}},
+		{"contained", [][]int{{0, 10}, {2, 4}}, [][]int{{0, 10}}},
+		{"invalid", [][]int{{4, 2}, {1}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeSpans(tt.spans); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeSpans() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
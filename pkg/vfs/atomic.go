@@ -0,0 +1,80 @@
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempInfix separates the name of the replaced file from the random suffix of its temp file
+const tempInfix = ".fscrub-"
+
+// IsTempFile reports whether name is a temp file written while replacing a file
+// They are renamed over the replaced file right away and should not be handled
+func IsTempFile(name string) bool {
+	base := filepath.Base(name)
+	i := strings.LastIndex(base, tempInfix)
+	if !strings.HasPrefix(base, ".") || i <= 0 {
+		return false
+	}
+	suffix := base[i+len(tempInfix):]
+	if suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileAtomic replaces the content of name by writing data into a temp file next to it and renaming it over name,
+// so neither readers nor interruptions ever see a partially written file. Symlinks are followed and existing files
+// keep their mode and owner. If the temp file can not be created or given the owner, e.g. because the dir is not
+// writable, the file is written in place instead. New files are written in place as well, there is nothing to roll back
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return ioutil.WriteFile(name, data, perm)
+	}
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+tempInfix)
+	if err != nil {
+		return ioutil.WriteFile(name, data, perm)
+	}
+	if err := chown(tmp, info); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return ioutil.WriteFile(name, data, perm)
+	}
+	err = writeTemp(tmp, data, info.Mode().Perm())
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// writeTemp writes data to tmp and closes it once data is on disk
+func writeTemp(tmp *os.File, data []byte, perm os.FileMode) error {
+	_, err := tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// FileInfo is a plain os.FileInfo for file systems not backed by the os
+type FileInfo struct {
+	FileName    string
+	FileSize    int64
+	FileMode    os.FileMode
+	FileModTime time.Time
+}
+
+// Name of the file without its dir
+func (i *FileInfo) Name() string {
+	return i.FileName
+}
+
+// Size of the file in bytes
+func (i *FileInfo) Size() int64 {
+	return i.FileSize
+}
+
+// Mode of the file
+func (i *FileInfo) Mode() os.FileMode {
+	return i.FileMode
+}
+
+// ModTime of the file
+func (i *FileInfo) ModTime() time.Time {
+	return i.FileModTime
+}
+
+// IsDir reports whether the file is a directory
+func (i *FileInfo) IsDir() bool {
+	return i.FileMode.IsDir()
+}
+
+// Sys returns nil as there is no underlying data source
+func (i *FileInfo) Sys() interface{} {
+	return nil
+}
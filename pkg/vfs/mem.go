@@ -0,0 +1,214 @@
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mem is an in-memory Filesystem
+// Paths are cleaned but not made absolute, so "a" and "./a" are the same file while "/a" is not
+type Mem struct {
+	m       sync.RWMutex
+	entries map[string]*memEntry
+}
+
+type memEntry struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMem returns an empty in-memory Filesystem containing only the root directories "." and "/"
+func NewMem() *Mem {
+	now := time.Now()
+	return &Mem{
+		entries: map[string]*memEntry{
+			".":                        {mode: os.ModeDir | 0755, modTime: now},
+			string(filepath.Separator): {mode: os.ModeDir | 0755, modTime: now},
+		},
+	}
+}
+
+func (fs *Mem) get(op, name string) (string, *memEntry, error) {
+	name = filepath.Clean(name)
+	e, ok := fs.entries[name]
+	if !ok {
+		return name, nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return name, e, nil
+}
+
+// Open the file for reading
+func (fs *Mem) Open(name string) (io.ReadCloser, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	name, e, err := fs.get("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	data := make([]byte, len(e.data))
+	copy(data, e.data)
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// WriteFile replacing its content, the parent directory has to exist
+func (fs *Mem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	name = filepath.Clean(name)
+	parent, ok := fs.entries[filepath.Dir(name)]
+	if !ok || !parent.mode.IsDir() {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	content := make([]byte, len(data))
+	copy(content, data)
+	if e, ok := fs.entries[name]; ok {
+		if e.mode.IsDir() {
+			return &os.PathError{Op: "open", Path: name, Err: errIsDir}
+		}
+		e.data = content
+		e.modTime = time.Now()
+		return nil
+	}
+	fs.entries[name] = &memEntry{
+		data:    content,
+		mode:    perm.Perm(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Stat returns the FileInfo
+func (fs *Mem) Stat(name string) (os.FileInfo, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	name, e, err := fs.get("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(name), nil
+}
+
+// Lstat returns the FileInfo, there are no symlinks in memory
+func (fs *Mem) Lstat(name string) (os.FileInfo, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	name, e, err := fs.get("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(name), nil
+}
+
+// ReadDir lists the directory sorted by name
+func (fs *Mem) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	name, e, err := fs.get("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	var infos []os.FileInfo
+	for path, e := range fs.entries {
+		if path != name && filepath.Dir(path) == name {
+			infos = append(infos, e.info(path))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// MkdirAll creates the directory including parents
+func (fs *Mem) MkdirAll(name string, perm os.FileMode) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	return fs.mkdirAll(filepath.Clean(name), perm)
+}
+
+func (fs *Mem) mkdirAll(name string, perm os.FileMode) error {
+	if e, ok := fs.entries[name]; ok {
+		if !e.mode.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		return nil
+	}
+	if err := fs.mkdirAll(filepath.Dir(name), perm); err != nil {
+		return err
+	}
+	fs.entries[name] = &memEntry{
+		mode:    os.ModeDir | perm.Perm(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Remove the file or empty directory
+func (fs *Mem) Remove(name string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	name, e, err := fs.get("remove", name)
+	if err != nil {
+		return err
+	}
+	if e.mode.IsDir() {
+		for path := range fs.entries {
+			if path != name && filepath.Dir(path) == name {
+				return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+			}
+		}
+	}
+	delete(fs.entries, name)
+	return nil
+}
+
+// Rename the file or directory including its content
+func (fs *Mem) Rename(oldname, newname string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	oldname, _, err := fs.get("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newname = filepath.Clean(newname)
+	if _, ok := fs.entries[filepath.Dir(newname)]; !ok {
+		return &os.PathError{Op: "rename", Path: newname, Err: os.ErrNotExist}
+	}
+	prefix := oldname + string(filepath.Separator)
+	moved := make(map[string]*memEntry)
+	for path, e := range fs.entries {
+		if path == oldname {
+			moved[newname] = e
+		} else if strings.HasPrefix(path, prefix) {
+			moved[filepath.Join(newname, strings.TrimPrefix(path, prefix))] = e
+		} else {
+			continue
+		}
+		delete(fs.entries, path)
+	}
+	for path, e := range moved {
+		fs.entries[path] = e
+	}
+	return nil
+}
+
+func (e *memEntry) info(path string) os.FileInfo {
+	return &FileInfo{
+		FileName:    filepath.Base(path),
+		FileSize:    int64(len(e.data)),
+		FileMode:    e.mode,
+		FileModTime: e.modTime,
+	}
+}
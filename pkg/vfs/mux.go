@@ -0,0 +1,85 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+var errCrossFilesystem = errors.New("rename across file systems")
+
+// Mux is a Filesystem routing paths prefixed with a scheme like "s3://bucket/key" to the
+// Filesystem registered for it, all other paths are passed to the fallback
+// Both "scheme://" and the form "scheme:/" produced by filepath.Join are routed
+type Mux struct {
+	fallback Filesystem
+	schemes  map[string]Filesystem
+}
+
+// NewMux passing all paths without registered scheme to fallback
+func NewMux(fallback Filesystem) *Mux {
+	return &Mux{
+		fallback: fallback,
+		schemes:  make(map[string]Filesystem),
+	}
+}
+
+// Handle paths of scheme with fs
+func (m *Mux) Handle(scheme string, fs Filesystem) *Mux {
+	m.schemes[scheme] = fs
+	return m
+}
+
+func (m *Mux) route(name string) Filesystem {
+	if i := strings.Index(name, ":/"); i > 0 {
+		if fs, ok := m.schemes[name[:i]]; ok {
+			return fs
+		}
+	}
+	return m.fallback
+}
+
+// Open the file for reading
+func (m *Mux) Open(name string) (io.ReadCloser, error) {
+	return m.route(name).Open(name)
+}
+
+// WriteFile replacing its content
+func (m *Mux) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return m.route(name).WriteFile(name, data, perm)
+}
+
+// Stat returns the FileInfo following symlinks
+func (m *Mux) Stat(name string) (os.FileInfo, error) {
+	return m.route(name).Stat(name)
+}
+
+// Lstat returns the FileInfo without following symlinks
+func (m *Mux) Lstat(name string) (os.FileInfo, error) {
+	return m.route(name).Lstat(name)
+}
+
+// ReadDir lists the directory
+func (m *Mux) ReadDir(name string) ([]os.FileInfo, error) {
+	return m.route(name).ReadDir(name)
+}
+
+// MkdirAll creates the directory including parents
+func (m *Mux) MkdirAll(name string, perm os.FileMode) error {
+	return m.route(name).MkdirAll(name, perm)
+}
+
+// Remove the file or empty directory
+func (m *Mux) Remove(name string) error {
+	return m.route(name).Remove(name)
+}
+
+// Rename the file or directory, both paths have to be on the same Filesystem
+func (m *Mux) Rename(oldname, newname string) error {
+	fs := m.route(oldname)
+	if fs != m.route(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errCrossFilesystem}
+	}
+	return fs.Rename(oldname, newname)
+}
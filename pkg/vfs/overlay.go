@@ -0,0 +1,189 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Overlay is a Filesystem which never modifies its base
+// All changes are kept in an in-memory layer on top of the base, making it
+// possible to run fscrub against real trees without touching them
+type Overlay struct {
+	base  Filesystem
+	layer *Mem
+
+	m       sync.RWMutex
+	removed map[string]bool
+}
+
+// NewOverlay on top of base
+func NewOverlay(base Filesystem) *Overlay {
+	return &Overlay{
+		base:    base,
+		layer:   NewMem(),
+		removed: make(map[string]bool),
+	}
+}
+
+// Layer returns the in-memory Filesystem holding all changes
+func (fs *Overlay) Layer() *Mem {
+	return fs.layer
+}
+
+func (fs *Overlay) isRemoved(name string) bool {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	name = filepath.Clean(name)
+	for {
+		if fs.removed[name] {
+			return true
+		}
+		parent := filepath.Dir(name)
+		if parent == name {
+			return false
+		}
+		name = parent
+	}
+}
+
+func (fs *Overlay) setRemoved(name string, removed bool) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	if removed {
+		fs.removed[filepath.Clean(name)] = true
+	} else {
+		delete(fs.removed, filepath.Clean(name))
+	}
+}
+
+// Open the file from the layer or the base
+func (fs *Overlay) Open(name string) (io.ReadCloser, error) {
+	if fs.isRemoved(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if _, err := fs.layer.Lstat(name); err == nil {
+		return fs.layer.Open(name)
+	}
+	return fs.base.Open(name)
+}
+
+// WriteFile into the layer
+func (fs *Overlay) WriteFile(name string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+	info, err := fs.Stat(dir)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if err := fs.layer.MkdirAll(dir, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := fs.layer.WriteFile(name, data, perm); err != nil {
+		return err
+	}
+	fs.setRemoved(name, false)
+	return nil
+}
+
+// Stat returns the FileInfo from the layer or the base
+func (fs *Overlay) Stat(name string) (os.FileInfo, error) {
+	if fs.isRemoved(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	if info, err := fs.layer.Stat(name); err == nil {
+		return info, nil
+	}
+	return fs.base.Stat(name)
+}
+
+// Lstat returns the FileInfo from the layer or the base
+func (fs *Overlay) Lstat(name string) (os.FileInfo, error) {
+	if fs.isRemoved(name) {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	if info, err := fs.layer.Lstat(name); err == nil {
+		return info, nil
+	}
+	return fs.base.Lstat(name)
+}
+
+// ReadDir merges the directory listings of layer and base
+func (fs *Overlay) ReadDir(name string) ([]os.FileInfo, error) {
+	if fs.isRemoved(name) {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	entries := make(map[string]os.FileInfo)
+	baseInfos, baseErr := fs.base.ReadDir(name)
+	for _, info := range baseInfos {
+		if !fs.isRemoved(filepath.Join(name, info.Name())) {
+			entries[info.Name()] = info
+		}
+	}
+	layerInfos, layerErr := fs.layer.ReadDir(name)
+	if baseErr != nil && layerErr != nil {
+		return nil, baseErr
+	}
+	for _, info := range layerInfos {
+		entries[info.Name()] = info
+	}
+	var infos []os.FileInfo
+	for _, info := range entries {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// MkdirAll creates the directory in the layer
+func (fs *Overlay) MkdirAll(name string, perm os.FileMode) error {
+	if err := fs.layer.MkdirAll(name, perm); err != nil {
+		return err
+	}
+	fs.setRemoved(name, false)
+	return nil
+}
+
+// Remove the file or empty directory, hiding it in the base
+func (fs *Overlay) Remove(name string) error {
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		infos, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(infos) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+		}
+	}
+	if _, err := fs.layer.Lstat(name); err == nil {
+		if err := fs.layer.Remove(name); err != nil {
+			return err
+		}
+	}
+	fs.setRemoved(name, true)
+	return nil
+}
+
+// Rename the file by copying it into the layer, directories can not be renamed
+func (fs *Overlay) Rename(oldname, newname string) error {
+	info, err := fs.Lstat(oldname)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "rename", Path: oldname, Err: errIsDir}
+	}
+	data, err := ReadFile(fs, oldname)
+	if err != nil {
+		return err
+	}
+	if err := fs.WriteFile(newname, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return fs.Remove(oldname)
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package vfs
+
+import "os"
+
+// chown is not supported on this platform, files keep the owner of the process writing them
+func chown(f *os.File, info os.FileInfo) error {
+	return nil
+}
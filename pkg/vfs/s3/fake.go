@@ -0,0 +1,242 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fakePageSize = 1000
+
+// Fake is an in-process s3 server implementing the subset of the API used by FS
+// It is meant for tests and can be served with net/http/httptest
+// Requests have to be signed, but signatures are not verified
+type Fake struct {
+	m        sync.RWMutex
+	buckets  map[string]map[string]*fakeObject
+	pageSize int
+}
+
+type fakeObject struct {
+	data        []byte
+	etag        string
+	contentType string
+	metadata    http.Header
+	modTime     time.Time
+}
+
+// NewFake server containing the empty buckets
+func NewFake(buckets ...string) *Fake {
+	f := &Fake{
+		buckets:  make(map[string]map[string]*fakeObject),
+		pageSize: fakePageSize,
+	}
+	for _, b := range buckets {
+		f.buckets[b] = make(map[string]*fakeObject)
+	}
+	return f
+}
+
+// ServeHTTP handles s3 requests using path-style addressing
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signAlgorithm+" ") {
+		fakeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.SplitN(p, "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		fakeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r, objects)
+	case key == "":
+		fakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		obj.writeHeader(w)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case r.Method == http.MethodPut:
+		f.put(w, r, objects, key)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *Fake) put(w http.ResponseWriter, r *http.Request, objects map[string]*fakeObject, key string) {
+	existing, exists := objects[key]
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || existing.etag != match) {
+		fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	obj := &fakeObject{
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    make(http.Header),
+		modTime:     time.Now().UTC().Truncate(time.Second),
+	}
+	if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+		source, _ = url.PathUnescape(source)
+		parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+		var src *fakeObject
+		if len(parts) == 2 {
+			src = f.buckets[parts[0]][parts[1]]
+		}
+		if src == nil {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		obj.data = src.data
+		obj.contentType = src.contentType
+		obj.metadata = src.metadata
+	} else {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			fakeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		obj.data = data
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, metaPrefix) {
+				obj.metadata[k] = v
+			}
+		}
+	}
+	sum := md5.Sum(obj.data)
+	obj.etag = `"` + hex.EncodeToString(sum[:]) + `"`
+	objects[key] = obj
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *Fake) list(w http.ResponseWriter, r *http.Request, objects map[string]*fakeObject) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	after := query.Get("continuation-token")
+	max := f.pageSize
+	if m, err := strconv.Atoi(query.Get("max-keys")); err == nil && m < max {
+		max = m
+	}
+
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Prefix                string
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{Prefix: prefix}
+
+	count := 0
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		// continuation tokens are the last returned key or prefix
+		if after != "" && k <= after {
+			continue
+		}
+		entry := k
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				entry = k[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if seen[entry] || (after != "" && strings.HasPrefix(after, entry)) {
+			continue
+		}
+		if count == max {
+			result.IsTruncated = true
+			break
+		}
+		seen[entry] = true
+		count++
+		result.NextContinuationToken = entry
+		if entry != k {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+			continue
+		}
+		obj := objects[k]
+		result.Contents = append(result.Contents, content{
+			Key:          k,
+			LastModified: obj.modTime.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+		})
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (o *fakeObject) writeHeader(w http.ResponseWriter) {
+	for k, v := range o.metadata {
+		w.Header()[k] = v
+	}
+	if o.contentType != "" {
+		w.Header().Set("Content-Type", o.contentType)
+	}
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	w.Header().Set("Last-Modified", o.modTime.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func fakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
@@ -0,0 +1,439 @@
+// Package s3 provides a vfs.Filesystem on top of S3-compatible object storage like AWS S3 or MinIO
+//
+// Paths have the form "s3://bucket/key", the cleaned form "s3:/bucket/key" produced by
+// filepath.Join is accepted as well. Directories do not exist in object storage, they are
+// derived from the "/" separated key prefixes.
+package s3
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+)
+
+// Scheme of s3 paths
+const Scheme = "s3"
+
+const (
+	metaPrefix         = "X-Amz-Meta-"
+	defaultContentType = "application/octet-stream"
+	defaultRegion      = "us-east-1"
+)
+
+var (
+	// ErrConflict is returned by WriteFile if the object changed since it was read
+	ErrConflict = errors.New("object was modified concurrently")
+
+	errIsDir     = errors.New("is a directory")
+	errNotEmpty  = errors.New("directory not empty")
+	errNoBucket  = errors.New("missing bucket")
+	errFileInDir = errors.New("can not create directories in object storage")
+)
+
+// Config of the s3 endpoint
+type Config struct {
+	// Endpoint url of the service, e.g. https://s3.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Client used for all requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// FS is the Filesystem of all buckets reachable through an s3 endpoint
+// Objects are written back using the ETag they had when they were opened as precondition,
+// so concurrent modifications are not overwritten but reported as ErrConflict.
+// The ETag is kept until the object is written or all readers of it are closed
+type FS struct {
+	client *client
+
+	m    sync.Mutex
+	meta map[string]objectMeta
+}
+
+// objectMeta is the state of an object when it was opened
+type objectMeta struct {
+	etag        string
+	contentType string
+	metadata    http.Header
+	// readers still open
+	readers int
+}
+
+// New s3 Filesystem for the endpoint of cfg
+func New(cfg Config) (*FS, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("s3 endpoint must not be empty")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.New("s3 endpoint must be an http or https url")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &FS{
+		client: &client{
+			endpoint:  endpoint,
+			region:    cfg.Region,
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			http:      cfg.Client,
+			now:       time.Now,
+		},
+		meta: make(map[string]objectMeta),
+	}, nil
+}
+
+// IsPath reports whether name is an s3 path
+func IsPath(name string) bool {
+	return strings.HasPrefix(name, Scheme+":")
+}
+
+// split name into bucket and key, the key of a bucket root is empty
+func split(op, name string) (string, string, error) {
+	p := strings.TrimPrefix(name, Scheme+":")
+	p = strings.Trim(path.Clean("/"+p), "/")
+	parts := strings.SplitN(p, "/", 2)
+	if parts[0] == "" {
+		return "", "", &os.PathError{Op: op, Path: name, Err: errNoBucket}
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// pathError converts response errors to the matching os errors
+func pathError(op, name string, err error) error {
+	if rerr, ok := err.(*responseError); ok {
+		switch rerr.Status {
+		case http.StatusNotFound:
+			err = os.ErrNotExist
+		case http.StatusForbidden:
+			err = os.ErrPermission
+		case http.StatusPreconditionFailed:
+			err = ErrConflict
+		}
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// IsConflict reports whether err was caused by a failed conditional write
+func IsConflict(err error) bool {
+	if perr, ok := err.(*os.PathError); ok {
+		err = perr.Err
+	}
+	return err == ErrConflict
+}
+
+func metaOf(header http.Header) objectMeta {
+	meta := objectMeta{
+		etag:        header.Get("ETag"),
+		contentType: header.Get("Content-Type"),
+		metadata:    make(http.Header),
+	}
+	for k, v := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), metaPrefix) {
+			meta.metadata[k] = v
+		}
+	}
+	return meta
+}
+
+// remember the object state of a new reader
+func (fs *FS) remember(bucket, key string, header http.Header) {
+	meta := metaOf(header)
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	meta.readers = fs.meta[bucket+"/"+key].readers + 1
+	fs.meta[bucket+"/"+key] = meta
+}
+
+// release a reader, forgetting the object state once no reader is left
+func (fs *FS) release(bucket, key string) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	meta, ok := fs.meta[bucket+"/"+key]
+	if !ok {
+		return
+	}
+	meta.readers--
+	if meta.readers > 0 {
+		fs.meta[bucket+"/"+key] = meta
+		return
+	}
+	delete(fs.meta, bucket+"/"+key)
+}
+
+func (fs *FS) recall(bucket, key string) (objectMeta, bool) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	meta, ok := fs.meta[bucket+"/"+key]
+	return meta, ok
+}
+
+func (fs *FS) forget(bucket, key string) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	delete(fs.meta, bucket+"/"+key)
+}
+
+// Open the object for reading
+// The ETag of the object is remembered for writing it back later on
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	bucket, key, err := split("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	resp, err := fs.client.do(http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	fs.remember(bucket, key, resp.Header)
+	return &object{ReadCloser: resp.Body, release: func() { fs.release(bucket, key) }}, nil
+}
+
+// object is the body of an opened object, releasing its state once closed
+type object struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (o *object) Close() error {
+	err := o.ReadCloser.Close()
+	o.once.Do(o.release)
+	return err
+}
+
+// WriteFile replacing the content of the object
+// Content type and metadata of existing objects are kept and the write only succeeds
+// if the object was not modified since it was opened, perm is ignored.
+// Objects not open are written back if unchanged since their current state was fetched
+func (fs *FS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	bucket, key, err := split("write", name)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return &os.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	meta, ok := fs.recall(bucket, key)
+	if !ok {
+		resp, err := fs.client.do(http.MethodHead, bucket, key, nil, nil, nil)
+		if err == nil {
+			resp.Body.Close()
+			meta, ok = metaOf(resp.Header), true
+		} else if rerr, isResp := err.(*responseError); !isResp || rerr.Status != http.StatusNotFound {
+			return pathError("write", name, err)
+		}
+	}
+
+	header := make(http.Header)
+	if ok {
+		for k, v := range meta.metadata {
+			header[k] = v
+		}
+		header.Set("Content-Type", meta.contentType)
+		header.Set("If-Match", meta.etag)
+	} else {
+		header.Set("Content-Type", contentType(key))
+		header.Set("If-None-Match", "*")
+	}
+	if header.Get("Content-Type") == "" {
+		header.Del("Content-Type")
+	}
+	if header.Get("If-Match") == "" {
+		header.Del("If-Match")
+	}
+
+	resp, err := fs.client.do(http.MethodPut, bucket, key, nil, header, data)
+	if err != nil {
+		return pathError("write", name, err)
+	}
+	resp.Body.Close()
+	fs.forget(bucket, key)
+	return nil
+}
+
+func contentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return defaultContentType
+}
+
+// Stat returns the FileInfo of the object or the prefix
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	return fs.stat("stat", name)
+}
+
+// Lstat is the same as Stat, there are no symlinks in object storage
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	return fs.stat("lstat", name)
+}
+
+func (fs *FS) stat(op, name string) (os.FileInfo, error) {
+	bucket, key, err := split(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		if _, err := fs.client.list(bucket, "", "/", 1); err != nil {
+			return nil, pathError(op, name, err)
+		}
+		return dirInfo(bucket), nil
+	}
+
+	resp, err := fs.client.do(http.MethodHead, bucket, key, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &vfs.FileInfo{
+			FileName:    path.Base(key),
+			FileSize:    size,
+			FileMode:    0644,
+			FileModTime: modTime,
+		}, nil
+	}
+	if rerr, ok := err.(*responseError); !ok || rerr.Status != http.StatusNotFound {
+		return nil, pathError(op, name, err)
+	}
+
+	// no object, but there might be a prefix
+	result, err := fs.client.list(bucket, key+"/", "/", 1)
+	if err != nil {
+		return nil, pathError(op, name, err)
+	}
+	if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return dirInfo(path.Base(key)), nil
+}
+
+func dirInfo(name string) os.FileInfo {
+	return &vfs.FileInfo{
+		FileName: name,
+		FileMode: os.ModeDir | 0755,
+	}
+}
+
+// ReadDir lists the objects and prefixes directly below name sorted by name
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	bucket, key, err := split("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+	result, err := fs.client.list(bucket, prefix, "/", 0)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	if key != "" && len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	var infos []os.FileInfo
+	for _, obj := range result.Contents {
+		// skip directory placeholders created by some clients
+		if obj.Key == prefix {
+			continue
+		}
+		infos = append(infos, &vfs.FileInfo{
+			FileName:    strings.TrimPrefix(obj.Key, prefix),
+			FileSize:    obj.Size,
+			FileMode:    0644,
+			FileModTime: obj.LastModified,
+		})
+	}
+	for _, p := range result.CommonPrefixes {
+		infos = append(infos, dirInfo(strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// MkdirAll does nothing, prefixes come into existence with their first object
+// It fails if an object with the same name exists
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	info, err := fs.Stat(name)
+	if err == nil && !info.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: errFileInDir}
+	}
+	return nil
+}
+
+// Remove the object, prefixes can only be removed if they are empty
+func (fs *FS) Remove(name string) error {
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	bucket, key, _ := split("remove", name)
+	resp, err := fs.client.do(http.MethodDelete, bucket, key, nil, nil, nil)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	resp.Body.Close()
+	fs.forget(bucket, key)
+	return nil
+}
+
+// Rename the object by copying it server side including its metadata
+// Prefixes can not be renamed
+func (fs *FS) Rename(oldname, newname string) error {
+	info, err := fs.Lstat(oldname)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "rename", Path: oldname, Err: errIsDir}
+	}
+	oldBucket, oldKey, _ := split("rename", oldname)
+	newBucket, newKey, err := split("rename", newname)
+	if err != nil {
+		return err
+	}
+	if newKey == "" {
+		return &os.PathError{Op: "rename", Path: newname, Err: errIsDir}
+	}
+	header := http.Header{
+		"X-Amz-Copy-Source":        {encodePath("/" + oldBucket + "/" + oldKey)},
+		"X-Amz-Metadata-Directive": {"COPY"},
+	}
+	resp, err := fs.client.do(http.MethodPut, newBucket, newKey, nil, header, nil)
+	if err != nil {
+		return pathError("rename", newname, err)
+	}
+	resp.Body.Close()
+	return fs.Remove(oldname)
+}
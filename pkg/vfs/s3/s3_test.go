@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/playnet-public/fscrub/pkg/vfs"
+)
+
+func newTestFS(t *testing.T) (*FS, *Fake, func()) {
+	fake := NewFake("bucket")
+	server := httptest.NewServer(fake)
+	fs, err := New(Config{
+		Endpoint:  server.URL,
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	files := []string{
+		"s3://bucket/a.txt",
+		"s3://bucket/logs/b.log",
+		"s3://bucket/logs/old/c.log",
+		"s3://bucket/other/d.txt",
+	}
+	for _, name := range files {
+		if err := fs.WriteFile(name, []byte(filepath.Base(name)), 0644); err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+	}
+	return fs, fake, server.Close
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		wantErr  bool
+	}{
+		{"valid", "http://localhost:9000", false},
+		{"empty", "", true},
+		{"noScheme", "localhost:9000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{Endpoint: tt.endpoint})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{"s3://bucket/a/b.txt", "bucket", "a/b.txt", false},
+		{"s3:/bucket/a/b.txt", "bucket", "a/b.txt", false},
+		{"s3://bucket/", "bucket", "", false},
+		{"s3://bucket", "bucket", "", false},
+		{"s3://", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, key, err := split("test", tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("split() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bucket != tt.wantBucket || key != tt.wantKey {
+				t.Errorf("split() = %q, %q, want %q, %q", bucket, key, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestFS_Walk(t *testing.T) {
+	fs, fake, stop := newTestFS(t)
+	defer stop()
+	// force pagination
+	fake.pageSize = 1
+
+	var paths []string
+	err := vfs.Walk(fs, "s3://bucket/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == "other" {
+			return filepath.SkipDir
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := []string{
+		"s3://bucket/",
+		"s3:/bucket/a.txt",
+		"s3:/bucket/logs",
+		"s3:/bucket/logs/b.log",
+		"s3:/bucket/logs/old",
+		"s3:/bucket/logs/old/c.log",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Walk() = %v, want %v", paths, want)
+	}
+}
+
+func TestFS_Stat(t *testing.T) {
+	fs, _, stop := newTestFS(t)
+	defer stop()
+
+	info, err := fs.Stat("s3://bucket/logs/b.log")
+	if err != nil || info.IsDir() || info.Size() != 5 || info.Name() != "b.log" {
+		t.Errorf("Stat() on object = %v, %v", info, err)
+	}
+	info, err = fs.Stat("s3://bucket/logs")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat() on prefix = %v, %v", info, err)
+	}
+	if _, err := fs.Stat("s3://bucket/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat() on missing error = %v, want not exist", err)
+	}
+	if _, err := fs.Stat("s3://nobucket/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat() on missing bucket error = %v, want not exist", err)
+	}
+	if _, err := fs.Open("s3://bucket/missing"); !os.IsNotExist(err) {
+		t.Errorf("Open() on missing error = %v, want not exist", err)
+	}
+}
+
+func TestFS_WriteFile(t *testing.T) {
+	fs, fake, stop := newTestFS(t)
+	defer stop()
+	fake.buckets["bucket"]["page.html"] = &fakeObject{
+		data:        []byte("old"),
+		etag:        `"1"`,
+		contentType: "text/html",
+		metadata:    http.Header{"X-Amz-Meta-Author": {"jane"}},
+	}
+
+	// read and write back keeps content type and metadata
+	if _, err := vfs.ReadFile(fs, "s3://bucket/page.html"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("s3://bucket/page.html", []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if len(fs.meta) != 0 {
+		t.Errorf("FS keeps the state of %d objects no longer open", len(fs.meta))
+	}
+	obj := fake.buckets["bucket"]["page.html"]
+	if string(obj.data) != "new" || obj.contentType != "text/html" || obj.metadata.Get("X-Amz-Meta-Author") != "jane" {
+		t.Errorf("WriteFile() object = %q %q %v", obj.data, obj.contentType, obj.metadata)
+	}
+
+	// concurrent modification between read and write, a stat in between must not pick up the new ETag
+	file, err := fs.Open("s3://bucket/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	obj.etag = `"changed"`
+	if _, err := fs.Stat("s3://bucket/page.html"); err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WriteFile("s3://bucket/page.html", []byte("newer"), 0644)
+	if !IsConflict(err) {
+		t.Errorf("WriteFile() after concurrent change error = %v, want conflict", err)
+	}
+	if string(obj.data) != "new" {
+		t.Errorf("WriteFile() overwrote concurrent change")
+	}
+
+	// new objects get their content type from the extension
+	if err := fs.WriteFile("s3://bucket/new.json", []byte("{}"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if ct := fake.buckets["bucket"]["new.json"].contentType; ct != "application/json" {
+		t.Errorf("WriteFile() content type = %q, want application/json", ct)
+	}
+}
+
+func TestFS_RenameRemove(t *testing.T) {
+	fs, _, stop := newTestFS(t)
+	defer stop()
+
+	if err := fs.Rename("s3://bucket/a.txt", "s3://bucket/moved/a.txt"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	data, err := vfs.ReadFile(fs, "s3://bucket/moved/a.txt")
+	if err != nil || string(data) != "a.txt" {
+		t.Errorf("Rename() content = %q, %v", data, err)
+	}
+	if _, err := fs.Stat("s3://bucket/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Rename() kept source, error = %v", err)
+	}
+	if err := fs.Remove("s3://bucket/logs"); err == nil {
+		t.Errorf("Remove() on prefix expected error")
+	}
+	if err := fs.Remove("s3://bucket/moved/a.txt"); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := fs.Stat("s3://bucket/moved"); !os.IsNotExist(err) {
+		t.Errorf("Remove() kept prefix, error = %v", err)
+	}
+}
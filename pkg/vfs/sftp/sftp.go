@@ -0,0 +1,337 @@
+// Package sftp provides a vfs.Filesystem for remote directories reachable over SFTP
+//
+// Paths have the form "sftp://[user@]host[:port]/path", the cleaned form "sftp:/host/path"
+// produced by filepath.Join is accepted as well. The remote path is always absolute.
+// Connections are opened on first use per host and redialed once lost, they authenticate with a private key,
+// the host key has to be listed in a known_hosts file.
+package sftp
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Scheme of sftp paths
+const Scheme = "sftp"
+
+const (
+	defaultPort    = "22"
+	defaultTimeout = 30 * time.Second
+)
+
+var (
+	errNoHost          = errors.New("missing host")
+	errCrossConnection = errors.New("rename across hosts")
+)
+
+// Config used for all connections
+type Config struct {
+	// User to log in as if the path does not contain one
+	User string
+	// KeyFile is the private key used if Signer is nil
+	KeyFile string
+	// KnownHostsFile is used for checking host keys if HostKeyCallback is nil
+	KnownHostsFile  string
+	Signer          ssh.Signer
+	HostKeyCallback ssh.HostKeyCallback
+	// Timeout for establishing connections, defaults to 30s
+	Timeout time.Duration
+}
+
+// FS is the Filesystem of all hosts reachable over sftp
+type FS struct {
+	config *ssh.ClientConfig
+
+	m     sync.Mutex
+	conns map[string]*conn
+}
+
+type conn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+// New sftp Filesystem, loading key and known hosts from disk if required
+func New(cfg Config) (*FS, error) {
+	if cfg.Signer == nil {
+		if cfg.KeyFile == "" {
+			return nil, errors.New("sftp key file must not be empty")
+		}
+		key, err := ioutil.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Signer, err = ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HostKeyCallback == nil {
+		if cfg.KnownHostsFile == "" {
+			return nil, errors.New("sftp known hosts file must not be empty")
+		}
+		var err error
+		cfg.HostKeyCallback, err = knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &FS{
+		config: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(cfg.Signer)},
+			HostKeyCallback: cfg.HostKeyCallback,
+			Timeout:         cfg.Timeout,
+		},
+		conns: make(map[string]*conn),
+	}, nil
+}
+
+// IsPath reports whether name is an sftp path
+func IsPath(name string) bool {
+	return strings.HasPrefix(name, Scheme+":")
+}
+
+// split name into the host part including user and port and the absolute remote path
+func split(op, name string) (string, string, error) {
+	p := strings.TrimLeft(strings.TrimPrefix(name, Scheme+":"), "/")
+	parts := strings.SplitN(p, "/", 2)
+	if parts[0] == "" {
+		return "", "", &os.PathError{Op: op, Path: name, Err: errNoHost}
+	}
+	remote := "/"
+	if len(parts) == 2 {
+		remote = path.Clean("/" + parts[1])
+	}
+	return parts[0], remote, nil
+}
+
+// client returns the connection to host, dialing it if required
+func (fs *FS) client(op, name string) (*conn, string, error) {
+	host, remote, err := split(op, name)
+	if err != nil {
+		return nil, "", err
+	}
+	fs.m.Lock()
+	c, ok := fs.conns[host]
+	fs.m.Unlock()
+	if ok {
+		return c, remote, nil
+	}
+
+	// dial without holding the lock so a slow host does not block the others
+	c, err = fs.dial(host)
+	if err != nil {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: err}
+	}
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	if other, ok := fs.conns[host]; ok {
+		c.close()
+		return other, remote, nil
+	}
+	fs.conns[host] = c
+	go func() {
+		c.ssh.Wait()
+		fs.drop(host, c)
+	}()
+	return c, remote, nil
+}
+
+func (fs *FS) dial(host string) (*conn, error) {
+	config := *fs.config
+	addr := host
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		config.User = addr[:i]
+		addr = addr[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultPort)
+	}
+	sshClient, err := ssh.Dial("tcp", addr, &config)
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	return &conn{ssh: sshClient, sftp: sftpClient}, nil
+}
+
+// drop the connection to host if it is still c, so the next use redials
+func (fs *FS) drop(host string, c *conn) {
+	fs.m.Lock()
+	if fs.conns[host] == c {
+		delete(fs.conns, host)
+	}
+	fs.m.Unlock()
+	c.close()
+}
+
+func (c *conn) close() error {
+	c.sftp.Close()
+	return c.ssh.Close()
+}
+
+// lost reports whether err is caused by the connection instead of the operation
+func lost(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, target := range []error{sftp.ErrSSHFxConnectionLost, io.EOF, io.ErrUnexpectedEOF, io.ErrClosedPipe} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// do runs fn on the connection of name, redialing once if the connection was lost
+func (fs *FS) do(op, name string, fn func(c *sftp.Client, remote string) error) error {
+	c, remote, err := fs.client(op, name)
+	if err != nil {
+		return err
+	}
+	err = fn(c.sftp, remote)
+	if !lost(err) {
+		return err
+	}
+	host, _, _ := split(op, name)
+	fs.drop(host, c)
+	c, remote, err = fs.client(op, name)
+	if err != nil {
+		return err
+	}
+	return fn(c.sftp, remote)
+}
+
+// Close all connections
+func (fs *FS) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	var err error
+	for host, c := range fs.conns {
+		if cerr := c.close(); err == nil {
+			err = cerr
+		}
+		delete(fs.conns, host)
+	}
+	return err
+}
+
+// Open the file for reading
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	var file *sftp.File
+	err := fs.do("open", name, func(c *sftp.Client, remote string) (err error) {
+		file, err = c.Open(remote)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// WriteFile replacing its content, perm is only applied to new files
+func (fs *FS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return fs.do("write", name, func(c *sftp.Client, remote string) error {
+		_, statErr := c.Stat(remote)
+		if lost(statErr) {
+			return statErr
+		}
+		file, err := c.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && os.IsNotExist(statErr) {
+			err = c.Chmod(remote, perm)
+		}
+		return err
+	})
+}
+
+// Stat returns the FileInfo following symlinks
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := fs.do("stat", name, func(c *sftp.Client, remote string) (err error) {
+		info, err = c.Stat(remote)
+		return err
+	})
+	return info, err
+}
+
+// Lstat returns the FileInfo without following symlinks
+func (fs *FS) Lstat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := fs.do("lstat", name, func(c *sftp.Client, remote string) (err error) {
+		info, err = c.Lstat(remote)
+		return err
+	})
+	return info, err
+}
+
+// ReadDir lists the directory
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := fs.do("readdir", name, func(c *sftp.Client, remote string) (err error) {
+		infos, err = c.ReadDir(remote)
+		return err
+	})
+	return infos, err
+}
+
+// MkdirAll creates the directory including parents, perm is not applied
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	return fs.do("mkdir", name, func(c *sftp.Client, remote string) error {
+		return c.MkdirAll(remote)
+	})
+}
+
+// Remove the file or empty directory
+func (fs *FS) Remove(name string) error {
+	return fs.do("remove", name, func(c *sftp.Client, remote string) error {
+		return c.Remove(remote)
+	})
+}
+
+// Rename the file or directory on the same host, replacing newname if supported by the server
+func (fs *FS) Rename(oldname, newname string) error {
+	oldHost, _, err := split("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newHost, newRemote, err := split("rename", newname)
+	if err != nil {
+		return err
+	}
+	if oldHost != newHost {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errCrossConnection}
+	}
+	return fs.do("rename", oldname, func(c *sftp.Client, oldRemote string) error {
+		if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+			return c.PosixRename(oldRemote, newRemote)
+		}
+		return c.Rename(oldRemote, newRemote)
+	})
+}
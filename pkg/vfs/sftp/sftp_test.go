@@ -0,0 +1,274 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/playnet-public/fscrub/pkg/vfs"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// testServer serves the os file system over sftp, only accepting clientKey
+type testServer struct {
+	addr    string
+	hostKey ssh.Signer
+	l       net.Listener
+}
+
+func newTestServer(t *testing.T, clientKey ssh.PublicKey) *testServer {
+	hostKey := newSigner(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "game" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(c, config)
+		}
+	}()
+	return &testServer{addr: l.Addr().String(), hostKey: hostKey, l: l}
+}
+
+func serveConn(c net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func (s *testServer) knownHosts(t *testing.T, dir string, key ssh.PublicKey) string {
+	name := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{s.addr}, key) + "\n"
+	if err := ioutil.WriteFile(name, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func newTestTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sftpTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "logs", "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"logs/a.log", "logs/old/b.log"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantHost   string
+		wantRemote string
+		wantErr    bool
+	}{
+		{"sftp://game@host:2222/var/log", "game@host:2222", "/var/log", false},
+		{"sftp:/host/var/log/../tmp", "host", "/var/tmp", false},
+		{"sftp://host", "host", "/", false},
+		{"sftp://", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, remote, err := split("test", tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("split() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if host != tt.wantHost || remote != tt.wantRemote {
+				t.Errorf("split() = %q, %q, want %q, %q", host, remote, tt.wantHost, tt.wantRemote)
+			}
+		})
+	}
+}
+
+func TestFS(t *testing.T) {
+	clientKey := newSigner(t)
+	server := newTestServer(t, clientKey.PublicKey())
+	defer server.l.Close()
+	dir := newTestTree(t)
+	defer os.RemoveAll(dir)
+
+	fs, err := New(Config{
+		User:           "game",
+		Signer:         clientKey,
+		KnownHostsFile: server.knownHosts(t, dir, server.hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer fs.Close()
+	root := "sftp://" + server.addr + filepath.ToSlash(filepath.Join(dir, "logs"))
+
+	var paths []string
+	err = vfs.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := []string{
+		root,
+		filepath.Join(root, "a.log"),
+		filepath.Join(root, "old"),
+		filepath.Join(root, "old", "b.log"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Walk() = %v, want %v", paths, want)
+	}
+
+	name := filepath.Join(root, "old", "b.log")
+	if err := fs.WriteFile(name, []byte("scrubbed"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	data, err := vfs.ReadFile(fs, name)
+	if err != nil || string(data) != "scrubbed" {
+		t.Errorf("ReadFile() = %q, %v, want scrubbed", data, err)
+	}
+	if err := fs.Rename(name, name+".bak"); err != nil {
+		t.Errorf("Rename() error = %v", err)
+	}
+	if err := fs.Remove(name + ".bak"); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := fs.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("Stat() on removed error = %v, want not exist", err)
+	}
+}
+
+func TestFS_Redial(t *testing.T) {
+	clientKey := newSigner(t)
+	server := newTestServer(t, clientKey.PublicKey())
+	defer server.l.Close()
+	dir := newTestTree(t)
+	defer os.RemoveAll(dir)
+
+	fs, err := New(Config{
+		User:           "game",
+		Signer:         clientKey,
+		KnownHostsFile: server.knownHosts(t, dir, server.hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer fs.Close()
+	name := "sftp://" + server.addr + filepath.ToSlash(filepath.Join(dir, "logs", "a.log"))
+
+	if _, err := fs.Stat(name); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	fs.m.Lock()
+	for _, c := range fs.conns {
+		c.ssh.Close()
+	}
+	fs.m.Unlock()
+	if _, err := fs.Stat(name); err != nil {
+		t.Errorf("Stat() after lost connection error = %v", err)
+	}
+	if _, err := vfs.ReadFile(fs, name); err != nil {
+		t.Errorf("ReadFile() after lost connection error = %v", err)
+	}
+}
+
+func TestFS_Auth(t *testing.T) {
+	clientKey := newSigner(t)
+	server := newTestServer(t, clientKey.PublicKey())
+	defer server.l.Close()
+	dir := newTestTree(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		user    string
+		signer  ssh.Signer
+		hostKey ssh.PublicKey
+	}{
+		{"unknownHostKey", "game", clientKey, newSigner(t).PublicKey()},
+		{"wrongClientKey", "game", newSigner(t), server.hostKey.PublicKey()},
+		{"wrongUser", "root", clientKey, server.hostKey.PublicKey()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := New(Config{
+				User:           tt.user,
+				Signer:         tt.signer,
+				KnownHostsFile: server.knownHosts(t, dir, tt.hostKey),
+			})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer fs.Close()
+			if _, err := fs.Stat("sftp://" + server.addr + dir); err == nil {
+				t.Errorf("Stat() expected error")
+			}
+		})
+	}
+}
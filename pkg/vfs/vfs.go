@@ -0,0 +1,114 @@
+package vfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Filesystem abstracts all file system access of fscrub
+// Errors should be *os.PathError wrapping os.ErrNotExist etc. so os.IsNotExist and friends keep working
+type Filesystem interface {
+	Open(name string) (io.ReadCloser, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// ReadFile reads the whole file name from fs
+func ReadFile(fs Filesystem, name string) ([]byte, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// Walk the file tree rooted at root on fs, calling fn for each file or directory
+// It behaves like filepath.Walk, visiting entries in lexical order without following symlinks
+func Walk(fs Filesystem, root string, fn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fs, root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(fs Filesystem, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	entries, err := fs.ReadDir(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		name := filepath.Join(path, entry.Name())
+		err = walk(fs, name, entry, fn)
+		if err != nil {
+			if !entry.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OS is the Filesystem of the operating system
+type OS struct{}
+
+// Open the file for reading
+func (OS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// WriteFile replacing its content atomically, so an interrupted write leaves the previous content
+func (OS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(name, data, perm)
+}
+
+// Stat returns the FileInfo following symlinks
+func (OS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat returns the FileInfo without following symlinks
+func (OS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// ReadDir lists the directory
+func (OS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// MkdirAll creates the directory including parents
+func (OS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Remove the file or empty directory
+func (OS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename the file or directory
+func (OS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
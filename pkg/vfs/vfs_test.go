@@ -0,0 +1,271 @@
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestMem(t *testing.T) *Mem {
+	fs := NewMem()
+	if err := fs.MkdirAll("root/sub/deep", 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"root/a.txt":          "a",
+		"root/sub/b.txt":      "bb",
+		"root/sub/deep/c.txt": "ccc",
+	}
+	for name, content := range files {
+		if err := fs.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func walkPaths(t *testing.T, fs Filesystem, root string) []string {
+	var paths []string
+	err := Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == "skip" {
+			return filepath.SkipDir
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	return paths
+}
+
+func TestWalk(t *testing.T) {
+	fs := newTestMem(t)
+	if err := fs.MkdirAll("root/skip", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile("root/skip/x.txt", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"root", "root/a.txt", "root/sub", "root/sub/b.txt", "root/sub/deep", "root/sub/deep/c.txt"}
+	if got := walkPaths(t, fs, "root"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() = %v, want %v", got, want)
+	}
+	err := Walk(fs, "notexist", func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	if !os.IsNotExist(err) {
+		t.Errorf("Walk() error = %v, want not exist", err)
+	}
+}
+
+func TestMem(t *testing.T) {
+	fs := newTestMem(t)
+
+	data, err := ReadFile(fs, "./root/sub/b.txt")
+	if err != nil || string(data) != "bb" {
+		t.Errorf("Mem.Open() = %q, %v, want bb", data, err)
+	}
+	info, err := fs.Stat("root/sub/deep/c.txt")
+	if err != nil || info.Size() != 3 || info.IsDir() || info.Name() != "c.txt" {
+		t.Errorf("Mem.Stat() = %v, %v", info, err)
+	}
+	if _, err := fs.Open("root/sub"); err == nil {
+		t.Errorf("Mem.Open() on dir expected error")
+	}
+	if err := fs.WriteFile("missing/x.txt", nil, 0644); !os.IsNotExist(err) {
+		t.Errorf("Mem.WriteFile() without parent error = %v, want not exist", err)
+	}
+	if err := fs.Remove("root/sub"); err == nil {
+		t.Errorf("Mem.Remove() on non empty dir expected error")
+	}
+	if err := fs.Rename("root/sub", "root/moved"); err != nil {
+		t.Errorf("Mem.Rename() error = %v", err)
+	}
+	want := []string{"root", "root/a.txt", "root/moved", "root/moved/b.txt", "root/moved/deep", "root/moved/deep/c.txt"}
+	if got := walkPaths(t, fs, "root"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Mem.Rename() tree = %v, want %v", got, want)
+	}
+	if err := fs.Remove("root/a.txt"); err != nil {
+		t.Errorf("Mem.Remove() error = %v", err)
+	}
+	if _, err := fs.Lstat("root/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Mem.Lstat() after remove error = %v, want not exist", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	base := newTestMem(t)
+	fs := NewOverlay(base)
+
+	if err := fs.WriteFile("root/sub/b.txt", []byte("changed"), 0644); err != nil {
+		t.Fatalf("Overlay.WriteFile() error = %v", err)
+	}
+	if err := fs.WriteFile("root/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatalf("Overlay.WriteFile() error = %v", err)
+	}
+	if err := fs.Remove("root/a.txt"); err != nil {
+		t.Fatalf("Overlay.Remove() error = %v", err)
+	}
+	if err := fs.Rename("root/sub/deep/c.txt", "root/c.txt"); err != nil {
+		t.Fatalf("Overlay.Rename() error = %v", err)
+	}
+
+	data, err := ReadFile(fs, "root/sub/b.txt")
+	if err != nil || string(data) != "changed" {
+		t.Errorf("Overlay.Open() = %q, %v, want changed", data, err)
+	}
+	want := []string{"root", "root/c.txt", "root/new.txt", "root/sub", "root/sub/b.txt", "root/sub/deep"}
+	if got := walkPaths(t, fs, "root"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Overlay tree = %v, want %v", got, want)
+	}
+
+	// base stays untouched
+	data, err = ReadFile(base, "root/sub/b.txt")
+	if err != nil || string(data) != "bb" {
+		t.Errorf("Overlay modified base = %q, %v", data, err)
+	}
+	want = []string{"root", "root/a.txt", "root/sub", "root/sub/b.txt", "root/sub/deep", "root/sub/deep/c.txt"}
+	if got := walkPaths(t, base, "root"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Overlay modified base tree = %v, want %v", got, want)
+	}
+}
+
+func TestOS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfsTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := OS{}
+	if err := fs.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("OS.MkdirAll() error = %v", err)
+	}
+	name := filepath.Join(dir, "sub", "a.txt")
+	if err := fs.WriteFile(name, []byte("a"), 0644); err != nil {
+		t.Fatalf("OS.WriteFile() error = %v", err)
+	}
+	if err := fs.Rename(name, name+".bak"); err != nil {
+		t.Fatalf("OS.Rename() error = %v", err)
+	}
+	data, err := ReadFile(fs, name+".bak")
+	if err != nil || string(data) != "a" {
+		t.Errorf("OS.Open() = %q, %v, want a", data, err)
+	}
+	want := []string{dir, filepath.Join(dir, "sub"), name + ".bak"}
+	if got := walkPaths(t, fs, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() on OS = %v, want %v", got, want)
+	}
+	if err := fs.Remove(name + ".bak"); err != nil {
+		t.Errorf("OS.Remove() error = %v", err)
+	}
+}
+
+func TestOS_WriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfsTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(name, []byte("old content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(name, link); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers of the old file keep seeing the old content, as it gets replaced instead of truncated
+	old, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if err := (OS{}).WriteFile(link, []byte("new"), 0666); err != nil {
+		t.Fatalf("OS.WriteFile() error = %v", err)
+	}
+	if data, _ := ioutil.ReadAll(old); string(data) != "old content" {
+		t.Errorf("reader of the replaced file got %q", data)
+	}
+	if data, err := ioutil.ReadFile(name); err != nil || string(data) != "new" {
+		t.Errorf("OS.WriteFile() wrote %q, %v, want new", data, err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("OS.WriteFile() replaced the symlink")
+	}
+	if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("OS.WriteFile() changed the mode to %v, %v", info.Mode(), err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("OS.WriteFile() left %d files behind, want 2", len(entries))
+	}
+}
+
+func TestIsTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfsTests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmp, err := ioutil.TempFile(dir, ".a.log"+tempInfix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{tmp.Name(), true},
+		{"/logs/.a.log.fscrub-123", true},
+		{"/logs/a.log.fscrub-123", false},
+		{"/logs/.a.log.fscrub-", false},
+		{"/logs/.a.log.fscrub-old", false},
+		{"/logs/.fscrub-123", false},
+		{"/logs/a.log", false},
+	}
+	for _, tt := range tests {
+		if got := IsTempFile(tt.name); got != tt.want {
+			t.Errorf("IsTempFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMux(t *testing.T) {
+	local := newTestMem(t)
+	remote := newTestMem(t)
+	fs := NewMux(local).Handle("mem", remote)
+
+	tests := []struct {
+		name string
+		path string
+		want Filesystem
+	}{
+		{"fallback", "root/a.txt", local},
+		{"scheme", "mem://root/a.txt", remote},
+		{"cleanedScheme", "mem:/root/a.txt", remote},
+		{"unknownScheme", "s3://root/a.txt", local},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fs.route(tt.path); got != tt.want {
+				t.Errorf("Mux.route() = %p, want %p", got, tt.want)
+			}
+		})
+	}
+	if err := fs.Rename("root/a.txt", "mem://root/a.txt"); err == nil {
+		t.Errorf("Mux.Rename() across file systems expected error")
+	}
+}